@@ -0,0 +1,100 @@
+package session
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultChallengeExpiry   = 5 * time.Minute
+	defaultChallengeAttempts = 5
+)
+
+// Challenge is the short-lived "pending MFA" state of a user who has
+// supplied a valid password but has yet to supply a second factor.
+//
+// The client holds the challenge token in the `<host>_mfa` cookie;
+// only its hash is stored.
+type Challenge struct {
+	ID        int64     `gorm:"auto_increment;unique_index;primary_key;column:id"`
+	UserID    int64     `gorm:"not null;column:user_id"` // [users].[id]
+	Hash      string    `gorm:"size:64;not null;column:hash"`
+	Host      string    `gorm:"column:host"`
+	KeepAlive bool      `gorm:"column:keep-alive"`
+	Attempts  int       `gorm:"column:attempts"`
+	Created   time.Time `gorm:"not null;column:created"`
+	Expires   time.Time `gorm:"not null;column:expires"`
+}
+
+// TableName Set Challenge's table name to be `challenges`
+func (Challenge) TableName() string {
+	return "challenges"
+}
+
+// IsValid returns true if the challenge has not expired nor
+// exhausted its attempts.
+func (c *Challenge) IsValid() bool {
+	if c.ID == 0 {
+		return false
+	}
+	return c.Attempts < defaultChallengeAttempts && time.Now().Before(c.Expires)
+}
+
+// beginChallenge stores a challenge for u and sets the `<host>_mfa` cookie.
+func (s *Service) beginChallenge(g *gin.Context, u *User, keepAlive bool) error {
+	expiry := defaultChallengeExpiry
+	if s.MFAExpiry > 0 {
+		expiry = s.MFAExpiry
+	}
+	sh := s.SessHost()
+	token := NewToken(defaultTokenSize)
+	t := time.Now()
+	c := Challenge{UserID: u.ID, Hash: hashToken(token), Host: sh, KeepAlive: keepAlive, Created: t, Expires: t.Add(expiry)}
+	if err := storage().ChallengeCreate(&c); err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return err
+	}
+	SetCookieSessOnly(g, sh+"_mfa", token)
+	return nil
+}
+
+// pendingChallenge returns the valid challenge referenced by the
+// `<host>_mfa` cookie and the user it belongs to.
+func (s *Service) pendingChallenge(g *gin.Context) (Challenge, User, bool) {
+	sh := s.SessHost()
+	u := User{}
+	token := getCookieValue(sh+"_mfa", g)
+	if token == "" {
+		return Challenge{}, u, false
+	}
+	c, err := storage().ChallengeByHash(hashToken(token))
+	if err != nil || c.Host != sh || !c.IsValid() {
+		return c, u, false
+	}
+	return c, u, u.ByID(c.UserID)
+}
+
+// failChallenge counts a failed attempt against c and, as a failed
+// login, against client and u so that codes can not be guessed by
+// starting new challenges (see `LoginLocked`).
+func (s *Service) failChallenge(client string, c *Challenge, u *User) {
+	c.Attempts++
+	storage().ChallengeSave(c)
+	s.LoginFailed(client, u)
+}
+
+// endChallenge deletes c and the `<host>_mfa` cookie.
+// Returns false if c was already consumed.
+func (s *Service) endChallenge(g *gin.Context, c *Challenge) bool {
+	SetCookieDestroy(g, s.SessHost()+"_mfa")
+	return storage().ChallengeDelete(c.ID) == nil
+}
+
+// EnsureTableChallenges creates table [challenges] if not exist.
+func EnsureTableChallenges() {
+	if err := storage().EnsureChallenges(); err != nil {
+		fmt.Printf("error(ensure-table-challenges): %v\n", err)
+	}
+}
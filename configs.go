@@ -15,6 +15,9 @@ var (
 	defaultSessionLength, _ = time.ParseDuration("12h")
 	unknownclient           = "unknown-client"
 	dataLogging             = false
+	defaultDeviceYears      = 2
+	defaultResetExpiry      = time.Hour
+	defaultTokenSize        = 32
 )
 
 // SetDataLogging allows you to turn on or off GORM data logging.
@@ -27,6 +30,9 @@ func SetDataLogging(value bool) {
 //
 // Note that the internally defined salt size is 48 while its
 // commonly (in the wild) something <= 32 bytes.
+//
+// sys and source are used to create the default `GormStore`
+// unless the service was already configured with a `Store`.
 func SetDefaults(sys, source string, saltSize, hashKeyLen int) {
 	datasource = source
 	datasys = sys
@@ -38,6 +44,10 @@ func SetDefaults(sys, source string, saltSize, hashKeyLen int) {
 	}
 	EnsureTableUsers()
 	EnsureTableSessions()
+	EnsureTableResets()
+	EnsureTableChallenges()
+	EnsureTableRecoveryCodes()
+	EnsureTableThrottles()
 }
 
 // returns calculated duration or on error the default session length '2hr'
@@ -71,3 +81,37 @@ func getClientString(client interface{}) string {
 	}
 	return clistr
 }
+
+// getDeviceString returns the device-id used to key a user's sessions
+// per device (browser).
+//
+// For a *gin.Context this is the value of the `<host>_dev` cookie
+// or an empty string if the client has none; otherwise its the
+// client string (see `getClientString`).
+func getDeviceString(client interface{}, host string) string {
+	if g, ok := client.(*gin.Context); ok {
+		return getCookieValue(host+"_dev", g)
+	}
+	return getClientString(client)
+}
+
+// newDeviceString creates a new device-id and, given a *gin.Context,
+// stores it to the `<host>_dev` cookie.
+func newDeviceString(client interface{}, host string) string {
+	g, ok := client.(*gin.Context)
+	if !ok {
+		return getClientString(client)
+	}
+	device := toUBase64(NewSaltString(24))
+	SetCookieExpires(g, host+"_dev", device, time.Now().AddDate(defaultDeviceYears, 0, 0))
+	return device
+}
+
+// getAgentString returns the (truncated) User-Agent of a *gin.Context
+// or an empty string.
+func getAgentString(client interface{}) string {
+	if g, ok := client.(*gin.Context); ok {
+		return truncate(g.Request.UserAgent(), 255)
+	}
+	return ""
+}
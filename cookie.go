@@ -17,83 +17,123 @@ func (s *Service) CookieDefaults(cookieMonths int, httpOnly, isSecure bool) {
 	s.CookieSecure = isSecure
 }
 
+// CookieScope sets the Domain, Path and SameSite attributes of our cookies;
+// see `Service.CookieDomain`, `Service.CookiePath` and `Service.CookieSameSite`.
+//
+// As browsers drop SameSite=None cookies that are not Secure,
+// http.SameSiteNoneMode also sets `Service.CookieSecure`.
+func (s *Service) CookieScope(domain, path string, sameSite http.SameSite) {
+	s.CookieDomain = domain
+	s.CookiePath = path
+	s.CookieSameSite = sameSite
+	if sameSite == http.SameSiteNoneMode {
+		s.CookieSecure = true
+	}
+}
+
+// cookieName returns name with the `__Host-` or `__Secure-` prefix
+// if `Service.CookiePrefix` is set.
+//
+// Browsers only accept a `__Secure-` cookie if it is Secure and a `__Host-`
+// cookie if it is also scoped to Path "/" and no Domain; so is our choice.
+func cookieName(name string) string {
+	if !service.CookiePrefix || !service.CookieSecure {
+		return name
+	}
+	if service.CookieDomain == "" && cookiePath() == "/" {
+		return "__Host-" + name
+	}
+	return "__Secure-" + name
+}
+
+func cookiePath() string {
+	if service.CookiePath == "" {
+		return "/"
+	}
+	return service.CookiePath
+}
+
+// newCookie returns a cookie with our default settings.
+func newCookie(name string) *http.Cookie {
+	return &http.Cookie{
+		Name:     cookieName(name),
+		Path:     cookiePath(),
+		Domain:   service.CookieDomain,
+		Secure:   service.CookieSecure,
+		HttpOnly: service.CookieHTTPOnly,
+		SameSite: service.CookieSameSite,
+	}
+}
+
 // SetCookieDestroy will destroy a client session by destroying the cookie.
 // We are setting the http.Cookie.MaxAge to -1.
 //
 // Note: *Like `github.com/gogonic/gin`, we are applying `url.QueryEscape`
 // `value` stored to the cookie so be sure to UnEscape the value when retrieved.*
 func SetCookieDestroy(cli *gin.Context, name string) {
-	http.SetCookie(cli.Writer, &http.Cookie{
-		Name:     name,
-		MaxAge:   -1,
-		Path:     "/",
-		Secure:   service.CookieSecure,
-		HttpOnly: service.CookieHTTPOnly,
-	})
+	c := newCookie(name)
+	c.MaxAge = -1
+	http.SetCookie(cli.Writer, c)
 }
 
 // SetCookieSessOnly will set a cookie with our default settings.
 // Will expire with the browser session.
 //
-// See `CookieDefaults` in order to override default settings.
+// See `CookieDefaults` and `CookieScope` in order to override default settings.
+// The value is signed if `Service.CookieKeys` are configured.
 //
 // Note: *Like `github.com/gogonic/gin`, we are applying `url.QueryEscape`
 // `value` stored to the cookie so be sure to UnEscape the value when retrieved.*
 func SetCookieSessOnly(cli *gin.Context, name, value string) {
-	http.SetCookie(cli.Writer, &http.Cookie{
-		Name:     name,
-		Value:    url.QueryEscape(value),
-		Path:     "/",
-		Secure:   service.CookieSecure,
-		HttpOnly: service.CookieHTTPOnly,
-	})
+	c := newCookie(name)
+	c.Value = url.QueryEscape(signCookie(c.Name, value))
+	http.SetCookie(cli.Writer, c)
 }
 
 // SetCookieExpires will set a cookie with our default settings.
 //
-// See `CookieDefaults` in order to override default settings.
+// See `CookieDefaults` and `CookieScope` in order to override default settings.
+// The value is signed if `Service.CookieKeys` are configured.
 //
 // Note: *Like `github.com/gogonic/gin`, we are applying `url.QueryEscape`
 // `value` stored to the cookie so be sure to UnEscape the value when retrieved.*
 func SetCookieExpires(cli *gin.Context, name, value string, expire time.Time) {
-	http.SetCookie(cli.Writer, &http.Cookie{
-		Name:     name,
-		Value:    url.QueryEscape(value),
-		Expires:  expire,
-		Path:     "/",
-		Secure:   service.CookieSecure,
-		HttpOnly: service.CookieHTTPOnly,
-	})
+	c := newCookie(name)
+	c.Value = url.QueryEscape(signCookie(c.Name, value))
+	c.Expires = expire
+	http.SetCookie(cli.Writer, c)
 }
 
 // getCookie does what it says.  if there is an error the returned value is `nil`.
+//
+// cname is prefixed as by `cookieName`.
 func getCookie(cname string, client *gin.Context) *http.Cookie {
 	var result *http.Cookie
-	if xid, e := client.Request.Cookie(cname); e == nil {
+	if xid, e := client.Request.Cookie(cookieName(cname)); e == nil {
 		result = xid
 	}
 	return result
 }
 
 // getCookieValue returns a string value if present, or an empty string.
+//
+// If `Service.CookieKeys` are configured, a cookie that is not signed
+// by one of them is treated as not present.
 func getCookieValue(cname string, client *gin.Context) string {
-	cookie := getCookie(cname, client)
-	cookieValue := ""
-	if cookie != nil {
-		if sessid, x := url.QueryUnescape(cookie.Value); x == nil {
-			cookieValue = sessid
-		}
-	}
-	return cookieValue
+	return cookieValue(getCookie(cname, client))
 }
 
 // cookieValue takes in a `*http.Cookie` and attempts to return
 // a string value.  If no value (error), then we'll return an empty string.
+//
+// The signature is verified as in `getCookieValue`.
 func cookieValue(cookie *http.Cookie) string {
 	cookieValue := ""
 	if cookie != nil {
 		if sessid, x := url.QueryUnescape(cookie.Value); x == nil {
-			cookieValue = sessid
+			if value, ok := verifyCookie(cookie.Name, sessid); ok {
+				cookieValue = value
+			}
 		}
 	}
 	return cookieValue
@@ -117,20 +157,15 @@ func QueryCookieValidate(cookieName string, client *gin.Context) bool {
 
 	result := false
 
-	db, err := iniC("error(validate-session) loading database\n")
-	if err {
+	sesshash := hashToken(sessid)
+	sess, err := storage().SessionByCookie(clistr, cookieName, sesshash)
+	if err != nil {
 		return false
 	}
-
-	// db.LogMode(dataLogging)
-	sess := Session{}
-
-	// defer db.Close()
-	db.First(&sess, "[cli-key] = ? AND [host] = ? AND [sessid] = ?", clistr, cookieName, sessid)
-	// fmt.Printf("SESS\nsess: %s\ncook: %s\n", sess.SessID, sessid)
+	// fmt.Printf("SESS\nsess: %s\ncook: %s\n", sess.SessHash, sesshash)
 	// fmt.Printf("EXPR\nsess: %v\ncook: %v\n", sess.Expires, cookie.Expires)
 
-	if sess.SessID == sessid {
+	if compareBytes([]byte(sess.SessHash), []byte(sesshash)) {
 		result = time.Now().Before(sess.Expires)
 		// fmt.Printf("==> SESSION IS VALID\n")
 	}
@@ -139,7 +174,8 @@ func QueryCookieValidate(cookieName string, client *gin.Context) bool {
 }
 
 // QueryCookie looks in `sessions` table for a matching `sess_id`
-// and returns the matching `Session` if found or an empty session.
+// (by its hash) and returns the matching `Session` if found or an
+// empty session.  `Session.SessID` is set from the cookie.
 // (bool) Success value tells us if a match was found.
 //
 // THIS DOES NOT VALIDATE THE SESSION! IT JUST LOOKS
@@ -156,17 +192,15 @@ func QueryCookie(host string, client *gin.Context) (Session, bool) {
 	clistr := getClientString(client)
 	cookiesess := getCookieValue(host, client)
 
-	sess := Session{}
 	if cookiesess == "" {
-		return sess, false
+		return Session{}, false
 	}
-	db, err := iniC("error(validate-session) loading database\n")
-	if err {
+	sesshash := hashToken(cookiesess)
+	sess, err := storage().SessionByCookie(clistr, host, sesshash)
+	if err != nil {
 		return sess, false
 	}
-	// db.LogMode(dataLogging)
-	// defer db.Close()
-	db.First(&sess, "[cli-key] = ? AND [host] = ? AND [sessid] = ?", clistr, host, cookiesess)
-	// fmt.Printf("  --> SESSID MATCH: %v\n", sess.SessID == cookiesess)
-	return sess, sess.SessID == cookiesess
+	// fmt.Printf("  --> SESSID MATCH: %v\n", sess.SessHash == sesshash)
+	sess.SessID = cookiesess
+	return sess, compareBytes([]byte(sess.SessHash), []byte(sesshash))
 }
@@ -0,0 +1,50 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// signCookie appends to value an HMAC-SHA256 (of name and value) keyed
+// by the first of `Service.CookieKeys`.
+//
+// value is returned as is if no keys are configured.
+func signCookie(name, value string) string {
+	if service == nil || len(service.CookieKeys) == 0 {
+		return value
+	}
+	return value + "." + cookieMAC(service.CookieKeys[0], name, value)
+}
+
+// verifyCookie returns the value of a cookie created by `signCookie`
+// if its signature matches any of `Service.CookieKeys`.
+//
+// raw is returned as is if no keys are configured.
+func verifyCookie(name, raw string) (string, bool) {
+	if service == nil || len(service.CookieKeys) == 0 {
+		return raw, true
+	}
+	i := strings.LastIndexByte(raw, '.')
+	if i < 0 {
+		return "", false
+	}
+	value, mac := raw[:i], raw[i+1:]
+	for _, key := range service.CookieKeys {
+		if compareBytes([]byte(cookieMAC(key, name, value)), []byte(mac)) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// cookieMAC binds value to the cookie name so that a signed value can
+// not be replayed under another cookie.
+func cookieMAC(key []byte, name, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
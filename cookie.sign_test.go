@@ -0,0 +1,40 @@
+package session
+
+import "testing"
+
+func TestSignCookie(t *testing.T) {
+	defer func(s *Service) { service = s }(service)
+	oldKey, newKey := []byte("old-cookie-key"), []byte("new-cookie-key")
+
+	service = &Service{CookieKeys: [][]byte{oldKey}}
+	signed := signCookie("app_xo", "admin1")
+
+	tests := []struct {
+		name  string
+		keys  [][]byte
+		cname string
+		raw   string
+		want  string
+		ok    bool
+	}{
+		{"signed", [][]byte{oldKey}, "app_xo", signed, "admin1", true},
+		{"rotated", [][]byte{newKey, oldKey}, "app_xo", signed, "admin1", true},
+		{"retired key", [][]byte{newKey}, "app_xo", signed, "", false},
+		{"other cookie", [][]byte{oldKey}, "app_dev", signed, "", false},
+		{"forged value", [][]byte{oldKey}, "app_xo", "root" + signed[len("admin1"):], "", false},
+		{"unsigned", [][]byte{oldKey}, "app_xo", "admin1", "", false},
+		{"no keys", nil, "app_xo", "admin1", "admin1", true},
+	}
+	for _, tt := range tests {
+		service = &Service{CookieKeys: tt.keys}
+		got, ok := verifyCookie(tt.cname, tt.raw)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: verifyCookie = %q, %v; want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	service = &Service{}
+	if got := signCookie("app_xo", "admin1"); got != "admin1" {
+		t.Errorf("signCookie without keys = %q", got)
+	}
+}
@@ -0,0 +1,56 @@
+package session
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCookieName(t *testing.T) {
+	defer func(s *Service) { service = s }(service)
+	tests := []struct {
+		svc  Service
+		want string
+	}{
+		{Service{}, "app"},
+		{Service{CookiePrefix: true}, "app"}, // not Secure
+		{Service{CookiePrefix: true, CookieSecure: true}, "__Host-app"},
+		{Service{CookiePrefix: true, CookieSecure: true, CookiePath: "/"}, "__Host-app"},
+		{Service{CookiePrefix: true, CookieSecure: true, CookiePath: "/app/"}, "__Secure-app"},
+		{Service{CookiePrefix: true, CookieSecure: true, CookieDomain: "example.com"}, "__Secure-app"},
+	}
+	for _, tt := range tests {
+		svc := tt.svc
+		service = &svc
+		if got := cookieName("app"); got != tt.want {
+			t.Errorf("cookieName with %+v = %q, want %q", tt.svc, got, tt.want)
+		}
+	}
+}
+
+func TestServeCookieScope(t *testing.T) {
+	c := newTestClient(t, func(s *Service) {
+		s.CookieSecure = true
+		s.CookiePrefix = true
+		s.CookieScope("", "", http.SameSiteStrictMode)
+	})
+	defer c.close()
+	c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}})
+	for _, name := range []string{c.svc.SessHost(), c.svc.SessHost() + "_xo"} {
+		ck, ok := c.cookies["__Host-"+name]
+		if !ok {
+			t.Errorf("no cookie __Host-%s in %v", name, c.cookies)
+			continue
+		}
+		if !ck.Secure || ck.Path != "/" || ck.Domain != "" || ck.SameSite != http.SameSiteStrictMode {
+			t.Errorf("cookie %s: %+v", ck.Name, ck)
+		}
+	}
+	if _, j := c.do(http.MethodGet, "/stat/", nil); !j.Status {
+		t.Errorf("stat with prefixed cookies: %+v", j)
+	}
+	c.do(http.MethodGet, "/logout/", nil)
+	if _, ok := c.cookies["__Host-"+c.svc.SessHost()]; ok {
+		t.Error("prefixed cookie not destroyed")
+	}
+}
@@ -2,6 +2,10 @@ package session
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"runtime"
 
 	"golang.org/x/crypto/argon2"
@@ -20,6 +24,9 @@ var (
 // *note*: that defaults are `uint32` with exception to the int32
 // hashThreadCount is stored to.  By default defaultHashThreadCount
 // is set using runtime.NumCUP() internally.
+//
+// Parameters are stored with each hash (see `NewPasswordHash`) so
+// existing users still log in; their hashes are upgraded on login.
 func OverrideCrypto(hashMemSize, hashTime, hashKeyLength, hashThreadCount int64) {
 	if hashMemSize != -1 {
 		defaultHashMem = uint32(hashMemSize)
@@ -47,29 +54,51 @@ func NewSaltString(c int) string {
 	return bytesToBase64(NewSaltCSRNG(c))
 }
 
-// copyTo copys bytes into a byte array.
-func copyTo(dst []byte, src []byte, offset int) {
-	for j, k := range src {
-		dst[offset+j] = k
-	}
+// NewToken creates a random, URL-safe token from c CSRNG bytes.
+func NewToken(c int) string {
+	return base64.RawURLEncoding.EncodeToString(NewSaltCSRNG(c))
 }
 
-// compareBytes returns true on match.
-// It compares length and each byte of inputs.
-func compareBytes(a []byte, b []byte) bool {
+// hashToken returns the hex encoded SHA-256 of token.
+//
+// Tokens handed to a client are only ever stored to the database
+// in this form.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
 
-	if len(a) != len(b) {
+// isHashedToken reports wether s is of the form returned by `hashToken`.
+func isHashedToken(s string) bool {
+	if len(s) != hex.EncodedLen(sha256.Size) {
 		return false
 	}
-	for i, j := range a {
-		if b[i] != j {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
 			return false
 		}
 	}
 	return true
 }
 
+// copyTo copys bytes into a byte array.
+func copyTo(dst []byte, src []byte, offset int) {
+	for j, k := range src {
+		dst[offset+j] = k
+	}
+}
+
+// compareBytes returns true on match.
+// It compares length and each byte of inputs in constant time
+// (with respect to the content of the inputs).
+func compareBytes(a []byte, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
 // GetHash dammit.
+//
+// Users' passwords are no longer hashed this way (see `NewPasswordHash`)
+// but legacy `User.Hash` values are still validated with it.
 func GetHash(pass []byte, salt []byte) []byte {
 
 	salty := make([]byte, len(salt)+len(pass))
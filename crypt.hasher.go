@@ -0,0 +1,455 @@
+package session
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PasswordHasher creates and verifies encoded password hashes of one
+// algorithm.
+//
+// `Service.PasswordHasher` creates new hashes while any of
+// `Service.PasswordHashers` may verify an existing one, so users may be
+// imported from another system (see `ImportUser`) and are upgraded to
+// `Service.PasswordHasher` on their next login.
+type PasswordHasher interface {
+	// Hash returns password encoded with a fresh salt.
+	Hash(password string) (string, error)
+	// Verify checks password against encoded; stale is true when
+	// encoded was created with other than the hasher's parameters.
+	Verify(password, encoded string) (ok bool, stale bool, err error)
+	// Detect reports wether encoded is of this hasher's algorithm.
+	Detect(encoded string) bool
+}
+
+type (
+	// Argon2idHasher creates PHC argon2id hashes with the parameters
+	// set by `OverrideCrypto`; see `NewPasswordHash`.
+	Argon2idHasher struct{}
+	// BcryptHasher creates "$2a$" bcrypt hashes.
+	BcryptHasher struct {
+		Cost int // bcrypt.DefaultCost if zero
+	}
+	// ScryptHasher creates "$scrypt$ln=..,r=..,p=..$<salt>$<hash>" hashes.
+	ScryptHasher struct {
+		LogN   int // 15 if zero
+		R      int // 8 if zero
+		P      int // 1 if zero
+		KeyLen int // 32 if zero
+	}
+	// PBKDF2Hasher creates "$pbkdf2-<digest>$<rounds>$<salt>$<hash>" hashes
+	// (as passlib).  Django's "pbkdf2_sha256$<rounds>$<salt>$<hash>" form
+	// is also verified.
+	PBKDF2Hasher struct {
+		Digest     string // "sha1", "sha256" (default) or "sha512"
+		Iterations int    // 310000 if zero
+	}
+)
+
+// Limits on the cost parameters read from a stored hash so that an
+// imported hash can not exhaust memory or CPU once it is verified.
+// Hashes no costlier than a hasher's own parameters are always accepted.
+const (
+	maxHashMemory   = 256 << 20 // bytes; argon2 "m" or scrypt 128*N*r
+	maxHashTime     = 16        // argon2 "t"
+	maxScryptP      = 16
+	maxBcryptCost   = 16
+	maxPBKDF2Rounds = 10000000
+	maxHashKeyLen   = 64 // bytes; argon2 key length
+)
+
+// ErrHashCost is returned for a hash whose cost parameters exceed our limits.
+var ErrHashCost = errors.New("session: hash cost parameters exceed limits")
+
+// costChecker is implemented by hashers which can check the cost
+// parameters of a hash without verifying it (see `ImportUser`).
+type costChecker interface {
+	checkCost(encoded string) error
+}
+
+// checkHashCost returns an error if h's cost parameters of encoded
+// exceed our limits.
+func checkHashCost(h PasswordHasher, encoded string) error {
+	if c, ok := h.(costChecker); ok {
+		return c.checkCost(encoded)
+	}
+	return nil
+}
+
+var (
+	defaultScryptLogN      = 15
+	defaultPBKDF2Rounds    = 310000
+	errHasherUnsupported   = errors.New("session: password hasher does not support this hash")
+	defaultPasswordHashers = []PasswordHasher{Argon2idHasher{}, BcryptHasher{}, ScryptHasher{}, PBKDF2Hasher{}}
+)
+
+// passwordHasher is the hasher new hashes are created with.
+func passwordHasher() PasswordHasher {
+	if service != nil && service.PasswordHasher != nil {
+		return service.PasswordHasher
+	}
+	return Argon2idHasher{}
+}
+
+// detectHasher returns our service's `PasswordHasher` if it recognizes
+// encoded (primary is true) or else the first of `PasswordHashers` that does.
+func detectHasher(encoded string) (h PasswordHasher, primary bool, found bool) {
+	if h = passwordHasher(); h.Detect(encoded) {
+		return h, true, true
+	}
+	hashers := defaultPasswordHashers
+	if service != nil && service.PasswordHashers != nil {
+		hashers = service.PasswordHashers
+	}
+	for _, h = range hashers {
+		if h.Detect(encoded) {
+			return h, false, true
+		}
+	}
+	return nil, false, false
+}
+
+// hashPassword encodes password with our service's `PasswordHasher`
+// after applying the current pepper; pepperID is to be stored alongside
+// the hash.
+func hashPassword(password string) (hash string, pepperID string, err error) {
+	pepperID = currentPepperID()
+	if password, err = pepper(password, pepperID); err != nil {
+		return "", "", err
+	}
+	hash, err = passwordHasher().Hash(password)
+	return hash, pepperID, err
+}
+
+// verifyPassword checks password, peppered by pepperID, against encoded
+// using whichever hasher recognizes it.  stale is true if encoded should
+// be replaced by `hashPassword`.
+func verifyPassword(password, encoded, pepperID string) (ok bool, stale bool) {
+	h, primary, found := detectHasher(encoded)
+	if !found {
+		return false, false
+	}
+	password, err := pepper(password, pepperID)
+	if err != nil {
+		fmt.Printf("ERROR(verify-password): %s\n", err.Error())
+		return false, false
+	}
+	ok, stale, err = h.Verify(password, encoded)
+	if err != nil {
+		fmt.Printf("ERROR(verify-password): %s\n", err.Error())
+		return false, false
+	}
+	return ok, stale || !primary || pepperID != currentPepperID()
+}
+
+var (
+	dummyMutex sync.Mutex
+	dummyHash  string
+)
+
+// dummyVerify spends about as long as verifying password against a
+// user's hash would so that a failed login for an unknown user can not
+// be told apart (by timing) from one with a wrong password.
+func dummyVerify(password string) {
+	dummyMutex.Lock()
+	hash := dummyHash
+	dummyMutex.Unlock()
+	if hash == "" {
+		hash, _, _ = hashPassword(NewSaltString(defaultSaltSize))
+	}
+	if _, stale := verifyPassword(password, hash, currentPepperID()); stale {
+		// parameters changed since; use a current hash next time.
+		hash, _, _ = hashPassword(NewSaltString(defaultSaltSize))
+	}
+	dummyMutex.Lock()
+	dummyHash = hash
+	dummyMutex.Unlock()
+}
+
+// Hash implements PasswordHasher.
+func (Argon2idHasher) Hash(password string) (string, error) {
+	return NewPasswordHash(password), nil
+}
+
+// Verify implements PasswordHasher.
+func (Argon2idHasher) Verify(password, encoded string) (bool, bool, error) {
+	if _, _, _, err := decodeArgon2(encoded); err != nil {
+		return false, false, err
+	}
+	ok, stale := VerifyPasswordHash(password, encoded)
+	return ok, stale, nil
+}
+
+// Detect implements PasswordHasher.
+func (Argon2idHasher) Detect(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (Argon2idHasher) checkCost(encoded string) error {
+	_, _, _, err := decodeArgon2(encoded)
+	return err
+}
+
+func (h BcryptHasher) cost() int {
+	if h.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return h.Cost
+}
+
+// Hash implements PasswordHasher.
+//
+// bcrypt refuses passwords longer than 72 bytes.
+func (h BcryptHasher) Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), h.cost())
+	return string(b), err
+}
+
+// Verify implements PasswordHasher.
+func (h BcryptHasher) Verify(password, encoded string) (bool, bool, error) {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, err
+	}
+	if cost > maxBcryptCost && cost > h.cost() {
+		return false, false, ErrHashCost
+	}
+	switch err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); err {
+	case nil:
+		return true, cost != h.cost(), nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return false, false, nil
+	}
+	return false, false, err
+}
+
+func (h BcryptHasher) checkCost(encoded string) error {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err == nil && cost > maxBcryptCost && cost > h.cost() {
+		err = ErrHashCost
+	}
+	return err
+}
+
+// Detect implements PasswordHasher.
+func (BcryptHasher) Detect(encoded string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(encoded, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h ScryptHasher) params() (logN, r, p, keyLen int) {
+	logN, r, p, keyLen = h.LogN, h.R, h.P, h.KeyLen
+	if logN == 0 {
+		logN = defaultScryptLogN
+	}
+	if r == 0 {
+		r = 8
+	}
+	if p == 0 {
+		p = 1
+	}
+	if keyLen == 0 {
+		keyLen = 32
+	}
+	return
+}
+
+// Hash implements PasswordHasher.
+func (h ScryptHasher) Hash(password string) (string, error) {
+	logN, r, p, keyLen := h.params()
+	salt := NewSaltCSRNG(16)
+	key, err := scrypt.Key([]byte(password), salt, 1<<uint(logN), r, p, keyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", logN, r, p,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// overLimit reports whether scrypt parameters exceed both our limits
+// and h's own.
+func (h ScryptHasher) overLimit(logN, r, p int) bool {
+	cLogN, cR, cP, _ := h.params()
+	if logN <= cLogN && r <= cR && p <= cP {
+		return false
+	}
+	return r > maxHashMemory/128>>uint(logN) || p > maxScryptP
+}
+
+func decodeScrypt(encoded string) (logN, r, p int, salt, hash []byte, err error) {
+	// "", "scrypt", "ln=..,r=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return 0, 0, 0, nil, nil, ErrHashFormat
+	}
+	if _, err = fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &logN, &r, &p); err != nil || logN < 1 || logN > 30 || r < 1 || p < 1 {
+		return 0, 0, 0, nil, nil, ErrHashFormat
+	}
+	if salt, err = decodeBase64Any(parts[3]); err != nil {
+		return 0, 0, 0, nil, nil, ErrHashFormat
+	}
+	if hash, err = decodeBase64Any(parts[4]); err != nil || len(hash) == 0 {
+		return 0, 0, 0, nil, nil, ErrHashFormat
+	}
+	return logN, r, p, salt, hash, nil
+}
+
+// Verify implements PasswordHasher.
+func (h ScryptHasher) Verify(password, encoded string) (bool, bool, error) {
+	logN, r, p, salt, want, err := decodeScrypt(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	if h.overLimit(logN, r, p) {
+		return false, false, ErrHashCost
+	}
+	key, err := scrypt.Key([]byte(password), salt, 1<<uint(logN), r, p, len(want))
+	if err != nil {
+		return false, false, err
+	}
+	cLogN, cR, cP, cKeyLen := h.params()
+	return compareBytes(key, want), logN != cLogN || r != cR || p != cP || len(want) != cKeyLen, nil
+}
+
+// Detect implements PasswordHasher.
+func (ScryptHasher) Detect(encoded string) bool {
+	return strings.HasPrefix(encoded, "$scrypt$")
+}
+
+func (h ScryptHasher) checkCost(encoded string) error {
+	logN, r, p, _, _, err := decodeScrypt(encoded)
+	if err == nil && h.overLimit(logN, r, p) {
+		err = ErrHashCost
+	}
+	return err
+}
+
+func (h PBKDF2Hasher) digest() string {
+	if h.Digest == "" {
+		return "sha256"
+	}
+	return h.Digest
+}
+
+func (h PBKDF2Hasher) iterations() int {
+	if h.Iterations == 0 {
+		return defaultPBKDF2Rounds
+	}
+	return h.Iterations
+}
+
+func pbkdf2Digest(name string) (func() hash.Hash, int, bool) {
+	switch name {
+	case "sha1":
+		return sha1.New, sha1.Size, true
+	case "sha256":
+		return sha256.New, sha256.Size, true
+	case "sha512":
+		return sha512.New, sha512.Size, true
+	}
+	return nil, 0, false
+}
+
+// Hash implements PasswordHasher.
+func (h PBKDF2Hasher) Hash(password string) (string, error) {
+	fn, size, ok := pbkdf2Digest(h.digest())
+	if !ok {
+		return "", errHasherUnsupported
+	}
+	salt := NewSaltCSRNG(16)
+	key := pbkdf2.Key([]byte(password), salt, h.iterations(), size, fn)
+	return fmt.Sprintf("$pbkdf2-%s$%d$%s$%s", h.digest(), h.iterations(),
+		toAB64(salt), toAB64(key)), nil
+}
+
+// decodePBKDF2 parses the passlib or django form of a PBKDF2 hash.
+func decodePBKDF2(encoded string) (name string, rounds int, salt, hash []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	switch {
+	case len(parts) == 5 && strings.HasPrefix(parts[1], "pbkdf2-"):
+		// passlib: "", "pbkdf2-<digest>", rounds, ab64(salt), ab64(hash)
+		name = strings.TrimPrefix(parts[1], "pbkdf2-")
+		if salt, err = fromAB64(parts[3]); err == nil {
+			hash, err = fromAB64(parts[4])
+		}
+		parts = parts[1:]
+	case len(parts) == 4 && strings.HasPrefix(parts[0], "pbkdf2_"):
+		// django: "pbkdf2_<digest>", rounds, salt, base64(hash)
+		name = strings.TrimPrefix(parts[0], "pbkdf2_")
+		salt = []byte(parts[2])
+		hash, err = base64.StdEncoding.DecodeString(parts[3])
+	default:
+		return "", 0, nil, nil, ErrHashFormat
+	}
+	_, size, ok := pbkdf2Digest(name)
+	if !ok || err != nil || len(hash) == 0 {
+		return "", 0, nil, nil, ErrHashFormat
+	}
+	if rounds, err = strconv.Atoi(parts[1]); err != nil || rounds < 1 {
+		return "", 0, nil, nil, ErrHashFormat
+	}
+	if len(hash) > size {
+		// every further block of the digest's size costs as many rounds again.
+		return "", 0, nil, nil, ErrHashCost
+	}
+	return name, rounds, salt, hash, nil
+}
+
+// Verify implements PasswordHasher.
+func (h PBKDF2Hasher) Verify(password, encoded string) (bool, bool, error) {
+	name, rounds, salt, want, err := decodePBKDF2(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	if rounds > maxPBKDF2Rounds && rounds > h.iterations() {
+		return false, false, ErrHashCost
+	}
+	fn, _, _ := pbkdf2Digest(name)
+	key := pbkdf2.Key([]byte(password), salt, rounds, len(want), fn)
+	return compareBytes(key, want), name != h.digest() || rounds != h.iterations(), nil
+}
+
+// Detect implements PasswordHasher.
+func (PBKDF2Hasher) Detect(encoded string) bool {
+	return strings.HasPrefix(encoded, "$pbkdf2-") || strings.HasPrefix(encoded, "pbkdf2_")
+}
+
+func (h PBKDF2Hasher) checkCost(encoded string) error {
+	_, rounds, _, _, err := decodePBKDF2(encoded)
+	if err == nil && rounds > maxPBKDF2Rounds && rounds > h.iterations() {
+		err = ErrHashCost
+	}
+	return err
+}
+
+// toAB64 encodes b as passlib's "adapted base64" ('.' for '+', no padding).
+func toAB64(b []byte) string {
+	return strings.ReplaceAll(base64.RawStdEncoding.EncodeToString(b), "+", ".")
+}
+
+// fromAB64 decodes passlib's "adapted base64".
+func fromAB64(s string) ([]byte, error) {
+	return decodeBase64Any(strings.ReplaceAll(s, ".", "+"))
+}
+
+// decodeBase64Any decodes standard base64 with or without padding.
+func decodeBase64Any(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,166 @@
+package session
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHasherVerify(t *testing.T) {
+	// created by Python's hashlib for "password1".
+	tests := []struct {
+		name    string
+		h       PasswordHasher
+		encoded string
+	}{
+		{"passlib sha256", PBKDF2Hasher{}, "$pbkdf2-sha256$1000$c2FsdHNhbHRzYWx0c2FsdA$eUQkKFYuuSyvHUvLihrjcjciK5YEWMJ62FRs0ZWST7A"},
+		{"passlib sha1", PBKDF2Hasher{}, "$pbkdf2-sha1$1000$c2FsdHNhbHRzYWx0c2FsdA$KtmORZ0ociU7BMe7SeNzn014qhQ"},
+		{"django", PBKDF2Hasher{}, "pbkdf2_sha256$1000$Zx9Qw3Ks1pLm$IsxiRLl85xwcPOHcPoQEdYB+2vAq3K8Aysim/NmrRHk="},
+		{"scrypt", ScryptHasher{}, "$scrypt$ln=10,r=8,p=1$c2FsdHNhbHRzYWx0c2FsdA$WWKVckBOJagkjR8Imv21JNL6MKiJ6QyFlVHr3yHLvH4"},
+	}
+	for _, tt := range tests {
+		if !tt.h.Detect(tt.encoded) {
+			t.Errorf("%s: not detected", tt.name)
+			continue
+		}
+		ok, stale, err := tt.h.Verify("password1", tt.encoded)
+		if err != nil || !ok || !stale {
+			t.Errorf("%s: Verify = %v, %v, %v; want true, true (stale), nil", tt.name, ok, stale, err)
+		}
+		if ok, _, _ := tt.h.Verify("password2", tt.encoded); ok {
+			t.Errorf("%s: wrong password verified", tt.name)
+		}
+	}
+}
+
+func TestHasherRoundTrip(t *testing.T) {
+	OverrideCrypto(1024, 1, -1, -1)
+	defer OverrideCrypto(int64(64*1024), 2, -1, -1)
+	for _, h := range []PasswordHasher{
+		Argon2idHasher{},
+		BcryptHasher{Cost: bcrypt.MinCost},
+		ScryptHasher{LogN: 10},
+		PBKDF2Hasher{Iterations: 1000},
+		PBKDF2Hasher{Digest: "sha512", Iterations: 1000},
+	} {
+		encoded, err := h.Hash("password1")
+		if err != nil {
+			t.Fatalf("%T: %v", h, err)
+		}
+		if !h.Detect(encoded) {
+			t.Errorf("%T: does not detect %s", h, encoded)
+		}
+		if ok, stale, err := h.Verify("password1", encoded); !ok || stale || err != nil {
+			t.Errorf("%T: Verify(%s) = %v, %v, %v", h, encoded, ok, stale, err)
+		}
+		if ok, _, _ := h.Verify("password2", encoded); ok {
+			t.Errorf("%T: wrong password verified", h)
+		}
+	}
+}
+
+func TestHasherMalformed(t *testing.T) {
+	tests := []struct {
+		h       PasswordHasher
+		encoded string
+		want    error
+	}{
+		{Argon2idHasher{}, "$argon2id$v=19$m=65536,t=2$c2FsdA$aGFzaA", ErrHashFormat},
+		{Argon2idHasher{}, "$argon2id$v=16$m=65536,t=2,p=4$c2FsdA$aGFzaA", ErrHashFormat},
+		{Argon2idHasher{}, "$argon2id$v=19$m=65536,t=2,p=4$c2FsdA$", ErrHashFormat},
+		{Argon2idHasher{}, "$argon2id$v=19$m=4194304,t=2,p=4$c2FsdA$aGFzaA", ErrHashCost},
+		{Argon2idHasher{}, "$argon2id$v=19$m=65536,t=100,p=4$c2FsdA$aGFzaA", ErrHashCost},
+		{Argon2idHasher{}, "$argon2id$v=19$m=65536,t=2,p=4$c2FsdA$" + base64.RawStdEncoding.EncodeToString(make([]byte, 65)), ErrHashCost},
+		{ScryptHasher{}, "$scrypt$ln=10,r=8$c2FsdA$aGFzaA", ErrHashFormat},
+		{ScryptHasher{}, "$scrypt$ln=30,r=8,p=1$c2FsdA$aGFzaA", ErrHashCost},
+		{ScryptHasher{}, "$scrypt$ln=10,r=8,p=64$c2FsdA$aGFzaA", ErrHashCost},
+		{PBKDF2Hasher{}, "$pbkdf2-md5$1000$c2FsdA$aGFzaA", ErrHashFormat},
+		{PBKDF2Hasher{}, "$pbkdf2-sha256$x$c2FsdA$aGFzaA", ErrHashFormat},
+		{PBKDF2Hasher{}, "$pbkdf2-sha256$999999999$c2FsdA$aGFzaA", ErrHashCost},
+		{PBKDF2Hasher{}, "$pbkdf2-sha1$1000$c2FsdA$" + toAB64(make([]byte, 21)), ErrHashCost},
+		{PBKDF2Hasher{}, "pbkdf2_sha256$1000$salt$" + base64.StdEncoding.EncodeToString(make([]byte, 4096)), ErrHashCost},
+		{PBKDF2Hasher{}, "pbkdf2_sha256$1000$salt", ErrHashFormat},
+	}
+	for _, tt := range tests {
+		if _, _, err := tt.h.Verify("password1", tt.encoded); !errors.Is(err, tt.want) {
+			t.Errorf("Verify(%s) = %v, want %v", tt.encoded, err, tt.want)
+		}
+		if err := checkHashCost(tt.h, tt.encoded); !errors.Is(err, tt.want) {
+			t.Errorf("checkHashCost(%s) = %v, want %v", tt.encoded, err, tt.want)
+		}
+	}
+}
+
+func TestImportUser(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	django := "pbkdf2_sha256$1000$Zx9Qw3Ks1pLm$IsxiRLl85xwcPOHcPoQEdYB+2vAq3K8Aysim/NmrRHk="
+	u, err := ImportUser("admin1", django)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportUser("admin1", django); err != ErrUserExists {
+		t.Errorf("import of an existing name: %v", err)
+	}
+	if _, err := ImportUser("admin2", "md5$abc$def"); err != ErrHashFormat {
+		t.Errorf("import of an unknown hash: %v", err)
+	}
+	if _, err := ImportUser("admin2", "$pbkdf2-sha256$999999999$c2FsdA$aGFzaA"); err != ErrHashCost {
+		t.Errorf("import of a costly hash: %v", err)
+	}
+	if _, j := c.do(http.MethodGet, "/login/", url.Values{"user": {"admin1"}, "pass": {"password2"}}); j.Status {
+		t.Errorf("login with a wrong password: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/login/", url.Values{"user": {"admin1"}, "pass": {"password1"}}); !j.Status {
+		t.Fatalf("login with an imported hash: %+v", j)
+	}
+	if x := c.svc.Store.(*memStore).users[u.ID]; !strings.HasPrefix(x.Hash, "$argon2id$") {
+		t.Errorf("imported hash not replaced: %s", x.Hash)
+	}
+}
+
+func TestServicePasswordHasher(t *testing.T) {
+	c := newTestClient(t, func(s *Service) {
+		s.PasswordHasher = BcryptHasher{Cost: bcrypt.MinCost}
+		s.PasswordHashers = []PasswordHasher{BcryptHasher{}}
+	})
+	defer c.close()
+	user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+	c.do(http.MethodGet, "/register/", user)
+	u, _ := c.svc.Store.UserByName("admin1")
+	if !strings.HasPrefix(u.Hash, "$2") {
+		t.Fatalf("hash not created by bcrypt: %s", u.Hash)
+	}
+	c.do(http.MethodGet, "/logout/", nil)
+	if _, j := c.do(http.MethodGet, "/login/", user); !j.Status {
+		t.Errorf("login: %+v", j)
+	}
+	if _, err := ImportUser("admin2", "$scrypt$ln=10,r=8,p=1$c2FsdHNhbHRzYWx0c2FsdA$WWKVckBOJagkjR8Imv21JNL6MKiJ6QyFlVHr3yHLvH4"); err != ErrHashFormat {
+		t.Errorf("import of a hash not in PasswordHashers: %v", err)
+	}
+}
+
+func TestDummyVerify(t *testing.T) {
+	OverrideCrypto(1024, 1, -1, -1)
+	defer OverrideCrypto(int64(64*1024), 2, -1, -1)
+	dummyHash = ""
+	dummyVerify("password1")
+	first := dummyHash
+	if !(Argon2idHasher{}).Detect(first) {
+		t.Fatalf("dummy hash %q", first)
+	}
+	dummyVerify("password2")
+	if dummyHash != first {
+		t.Error("current dummy hash was replaced")
+	}
+	OverrideCrypto(2048, 1, -1, -1)
+	dummyVerify("password1")
+	if dummyHash == first {
+		t.Error("stale dummy hash was kept")
+	}
+}
@@ -0,0 +1,38 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// ErrPepperKey is returned when a hash names a pepper key ID that is
+// not in `Service.Peppers`.
+var ErrPepperKey = errors.New("session: unknown pepper key")
+
+// currentPepperID is the pepper key ID new hashes are created with.
+func currentPepperID() string {
+	if service == nil {
+		return ""
+	}
+	return service.PepperID
+}
+
+// pepper returns password keyed by the `Service.Peppers` secret named id
+// via HMAC-SHA256, or password as is if id is empty.
+func pepper(password, id string) (string, error) {
+	if id == "" {
+		return password, nil
+	}
+	var key []byte
+	if service != nil {
+		key = service.Peppers[id]
+	}
+	if len(key) == 0 {
+		return "", ErrPepperKey
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	return base64.RawStdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
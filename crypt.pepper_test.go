@@ -0,0 +1,68 @@
+package session
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestPepperRotation(t *testing.T) {
+	c := newTestClient(t, func(s *Service) {
+		s.Peppers = map[string][]byte{"k1": []byte("pepper-one")}
+		s.PepperID = "k1"
+	})
+	defer c.close()
+	m := c.svc.Store.(*memStore)
+	user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+	login := func() bool {
+		c.do(http.MethodGet, "/logout/", nil)
+		_, j := c.do(http.MethodGet, "/login/", user)
+		return j.Status
+	}
+	c.do(http.MethodGet, "/register/", user)
+	u, _ := m.UserByName("admin1")
+	if u.PepperID != "k1" {
+		t.Fatalf("stored pepper %q, want k1", u.PepperID)
+	}
+	if ok, _, _ := (Argon2idHasher{}).Verify("password1", u.Hash); ok {
+		t.Error("hash verifies without the pepper")
+	}
+	if !login() {
+		t.Fatal("login with pepper k1")
+	}
+
+	c.svc.Peppers["k2"] = []byte("pepper-two")
+	c.svc.PepperID = "k2"
+	if !login() {
+		t.Fatal("login after rotating to k2")
+	}
+	if u, _ = m.UserByName("admin1"); u.PepperID != "k2" {
+		t.Errorf("pepper not rotated: %q", u.PepperID)
+	}
+	delete(c.svc.Peppers, "k1")
+	if !login() {
+		t.Error("login after retiring k1")
+	}
+	delete(c.svc.Peppers, "k2")
+	if login() {
+		t.Error("login with a missing pepper")
+	}
+}
+
+func TestPepper(t *testing.T) {
+	defer func(s *Service) { service = s }(service)
+	service = &Service{Peppers: map[string][]byte{"k1": []byte("pepper-one")}}
+	if got, err := pepper("password1", ""); got != "password1" || err != nil {
+		t.Errorf("pepper without id = %q, %v", got, err)
+	}
+	a, err := pepper("password1", "k1")
+	if err != nil || a == "password1" {
+		t.Errorf("pepper = %q, %v", a, err)
+	}
+	if b, _ := pepper("password2", "k1"); b == a {
+		t.Error("pepper of different passwords match")
+	}
+	if _, err := pepper("password1", "k9"); err != ErrPepperKey {
+		t.Errorf("pepper with an unknown id: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package session
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ErrHashFormat is returned when a stored hash can not be parsed.
+var ErrHashFormat = errors.New("session: unrecognized hash format")
+
+// argon2Params are the parameters of an argon2id hash.
+type argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	KeyLen  uint32
+}
+
+// currentArgon2Params returns the parameters new hashes are created
+// with; see `OverrideCrypto`.
+func currentArgon2Params() argon2Params {
+	threads := defaultHashThread
+	if threads < 1 {
+		threads = 1
+	} else if threads > 255 {
+		threads = 255
+	}
+	return argon2Params{
+		Memory:  defaultHashMem,
+		Time:    defaultHashTime,
+		Threads: uint8(threads),
+		KeyLen:  defaultHashKeyLen,
+	}
+}
+
+// NewPasswordHash hashes password with a fresh salt and the current
+// argon2id parameters, returning a PHC string of the form
+//
+//	$argon2id$v=19$m=65536,t=2,p=4$<salt>$<hash>
+//
+// As the parameters are stored with the hash, changing them with
+// `OverrideCrypto` does not break existing hashes.
+func NewPasswordHash(password string) string {
+	return encodeArgon2(password, NewSaltCSRNG(defaultSaltSize), currentArgon2Params())
+}
+
+// VerifyPasswordHash checks password against a PHC string created by
+// `NewPasswordHash`.
+//
+// stale is true when the hash was created with parameters other than
+// the current ones and should be replaced by `NewPasswordHash`.
+func VerifyPasswordHash(password, encoded string) (ok bool, stale bool) {
+	p, salt, hash, err := decodeArgon2(encoded)
+	if err != nil {
+		return false, false
+	}
+	ok = compareBytes(argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(hash))), hash)
+	// parallelism defaults to the host's CPU count; it is not considered
+	// so that hosts of differing size do not keep rehashing.
+	c := currentArgon2Params()
+	stale = p.Memory != c.Memory || p.Time != c.Time || p.KeyLen != c.KeyLen || len(salt) != defaultSaltSize
+	return ok, stale
+}
+
+func encodeArgon2(password string, salt []byte, p argon2Params) string {
+	hash := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash))
+}
+
+func decodeArgon2(encoded string) (p argon2Params, salt, hash []byte, err error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return p, nil, nil, ErrHashFormat
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrHashFormat
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, ErrHashFormat
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, ErrHashFormat
+	}
+	if hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(hash) == 0 {
+		return p, nil, nil, ErrHashFormat
+	}
+	if p.Time == 0 || p.Threads == 0 {
+		return p, nil, nil, ErrHashFormat
+	}
+	if c := currentArgon2Params(); (p.Memory > c.Memory && uint64(p.Memory)*1024 > maxHashMemory) ||
+		(p.Time > c.Time && p.Time > maxHashTime) ||
+		(uint32(len(hash)) > c.KeyLen && len(hash) > maxHashKeyLen) {
+		return p, nil, nil, ErrHashCost
+	}
+	p.KeyLen = uint32(len(hash))
+	return p, salt, hash, nil
+}
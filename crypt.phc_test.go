@@ -0,0 +1,97 @@
+package session
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNewPasswordHash(t *testing.T) {
+	OverrideCrypto(1024, 1, -1, -1)
+	defer OverrideCrypto(int64(64*1024), 2, -1, -1)
+	encoded := NewPasswordHash("password1")
+	if ok, stale := VerifyPasswordHash("password1", encoded); !ok || stale {
+		t.Errorf("VerifyPasswordHash(%s) = %v, %v; want true, false", encoded, ok, stale)
+	}
+	if other := NewPasswordHash("password1"); other == encoded {
+		t.Error("NewPasswordHash reused a salt")
+	}
+	OverrideCrypto(2048, -1, -1, -1)
+	if ok, stale := VerifyPasswordHash("password1", encoded); !ok || !stale {
+		t.Errorf("after OverrideCrypto: VerifyPasswordHash = %v, %v; want true, true", ok, stale)
+	}
+}
+
+func TestVerifyPasswordHash(t *testing.T) {
+	encoded := encodeArgon2("password1", []byte("saltsaltsaltsalt"), argon2Params{Memory: 1024, Time: 1, Threads: 1, KeyLen: 32})
+	tests := []struct {
+		password string
+		ok       bool
+	}{
+		{"password1", true},
+		{"password2", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		ok, stale := VerifyPasswordHash(tt.password, encoded)
+		if ok != tt.ok {
+			t.Errorf("VerifyPasswordHash(%q) = %v, want %v", tt.password, ok, tt.ok)
+		}
+		if ok && !stale {
+			t.Errorf("VerifyPasswordHash(%q) not stale for other than current parameters", tt.password)
+		}
+	}
+}
+
+func TestDecodeArgon2(t *testing.T) {
+	if _, _, _, err := decodeArgon2("$argon2id$v=19$m=1024,t=1,p=2$c2FsdA$aGFzaA"); err != nil {
+		t.Errorf("decodeArgon2: %v", err)
+	}
+	for _, encoded := range []string{
+		"",
+		"aGFzaA",
+		"$argon2i$v=19$m=1024,t=1,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=16$m=1024,t=1,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=0,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1,p=2$c2FsdA$",
+		"$argon2id$v=19$m=1024,t=1,p=2$c2FsdA$!!",
+	} {
+		if _, _, _, err := decodeArgon2(encoded); err != ErrHashFormat {
+			t.Errorf("decodeArgon2(%q) = %v, want ErrHashFormat", encoded, err)
+		}
+	}
+}
+
+func TestPasswordHashUpgrade(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	m := c.svc.Store.(*memStore)
+	salt := NewSaltCSRNG(defaultSaltSize)
+	legacy := User{Name: "admin1", Salt: bytesToBase64(salt), Hash: bytesToBase64(GetPasswordHash("password1", salt))}
+	m.UserCreate(&legacy)
+	user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+
+	if _, j := c.do(http.MethodGet, "/login/", user); !j.Status {
+		t.Fatalf("login with a legacy hash: %+v", j)
+	}
+	u := m.users[legacy.ID]
+	if u.Salt != "" || !strings.HasPrefix(u.Hash, "$argon2id$") {
+		t.Fatalf("legacy hash not upgraded: %+v", u)
+	}
+	current := u.Hash
+	c.do(http.MethodGet, "/logout/", nil)
+	if _, j := c.do(http.MethodGet, "/login/", user); !j.Status || m.users[legacy.ID].Hash != current {
+		t.Errorf("current hash replaced: %+v", j)
+	}
+
+	OverrideCrypto(2048, -1, -1, -1)
+	c.do(http.MethodGet, "/logout/", nil)
+	if _, j := c.do(http.MethodGet, "/login/", user); !j.Status {
+		t.Fatalf("login after OverrideCrypto: %+v", j)
+	}
+	if u := m.users[legacy.ID]; u.Hash == current || !strings.Contains(u.Hash, "m=2048,") {
+		t.Errorf("stale hash not upgraded: %s", u.Hash)
+	}
+}
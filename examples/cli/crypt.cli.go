@@ -16,11 +16,10 @@
 //
 // 3. `sess.exe list` will list the entries in the sessions table and
 //    provide the User.Name for each row.
+//
+// 4. `sess.exe purge -grace <duration>` will delete expired sessions.
 package main
 
-// tickers would help mitigate sessions
-// https://gobyexample.com/tickers
-
 import (
 	"flag"
 	"fmt"
@@ -56,6 +55,9 @@ var (
 	fvUser    = fValidate.String("u", "admin", "speficy username.")
 	fvPass    = fValidate.String("p", "", "for validation and creation of a login profile.")
 	//
+	fPurge  = flag.NewFlagSet("purge", flag.ExitOnError)
+	fpGrace = fPurge.Duration("grace", 0, "keep sessions which expired less than grace ago.")
+	//
 	//fvalid   = flag.String("V", "", "for validation and creation of a login profile.")
 	//fsalt    = flag.String("salt", "", "[optional] supply salt and hash to validate -V <pass> (or fallback to db).")
 	//fhash    = flag.String("hash", "", "[optional] supply salt and hash to validate -V <pass> (or fallback to db).")
@@ -145,6 +147,13 @@ func main() {
 		}
 	case "list":
 		List()
+	case "purge":
+		fPurge.Parse(os.Args[2:])
+		svc := session.DefaultService()
+		svc.JanitorGrace = *fpGrace
+		session.SetupService(svc, nil, "sqlite3", *fdb, *fSaltLen, *fHashLen)
+		purged, err := svc.PurgeSessions()
+		fmt.Printf("--> purged %d sessions (error: %v)\n", purged, err)
 	default:
 		println()
 		flag.PrintDefaults()
@@ -161,12 +170,12 @@ func List() {
 	sessions, count := session.ListSessions()
 	fmt.Printf("--> found %d entries\n", count)
 	for _, x := range sessions {
-		fmt.Printf("--> '%s'\n  UserID: %v\n  CRD: %s\n  EXP: %s\n  SID: %s\n",
+		fmt.Printf("--> '%s'\n  UserID: %v\n  CRD: %s\n  EXP: %s\n  SID-SHA256: %s\n",
 			usermap[x.UserID].Name,
 			x.UserID,
 			x.Created.Format("20060102_1504.005"),
 			x.Expires.Format("20060102_1504.005"),
-			x.SessID)
+			x.SessHash)
 	}
 }
 
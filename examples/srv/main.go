@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	_ "gorm.io/driver/sqlite"
 
@@ -33,7 +35,7 @@ var (
 		URIMatchHandler: nil, // use default
 		URIAbortHandler: nil, // use default
 		// defaults the requestHandlers use to look up form values.
-		FormSession: session.FormSession{User: "user", Pass: "pass", Keep: "keep"},
+		FormSession: session.FormSession{User: "user", Pass: "pass", Keep: "keep", Sess: "sess", Label: "label", NewPass: "newpass", Token: "token", Code: "code", CSRF: "csrf"},
 	}
 )
 
@@ -46,6 +48,10 @@ var (
 // http://localhost:5500/register/?user=admin&pass=password
 // http://localhost:5500/register/?user=admin&pass=password&keep=true
 // http://localhost:5500/stat/
+// http://localhost:5500/password/?pass=password&newpass=secret
+// http://localhost:5500/sessions/
+// http://localhost:5500/sessions/name/?sess=1&label=laptop
+// http://localhost:5500/sessions/revoke/?sess=1
 // http://localhost:5500/logout/
 //
 
@@ -61,6 +67,9 @@ func main() {
 	// at this point you can override the crypto settings
 	// session.OverrideCrypto(...)
 
+	// delete expired sessions once an hour.
+	service.StartJanitor(context.Background(), time.Hour)
+
 	// this "index" is defined in service.URIEnforce,
 	// so you must be logged in to view it.
 	engine.GET("/index/", func(g *gin.Context) {
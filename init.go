@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -26,80 +29,36 @@ var (
 // https://github.com/glebarez/sqlite
 //
 
-// close on error (no message)
+// dialector returns the GORM dialector for the given data-system.
 //
-// As a precaution, do not attempt to perform complex or multiple
-// database operations — or rather perhaps, never load and attempt
-// to work with more than one loaded (.Open) database connection
+// "sqlite3", "sqlite" (or empty) expect source to be a file-path
+// such as "./data.db".
 //
-// returns true on error
-func dbinit() (*gorm.DB, bool) {
-	db, err := gorm.Open(sqlite.Open(datasource), &gorm.Config{Logger: newLogger})
-	result := false
-	if err != nil {
-		//db.Close()
-		result = true
-	}
-	return db, result
-}
-
-// no close on error (no message)
+// "postgres", "postgresql" and "pgx" expect a DSN such as
+// "host=localhost user=gorm password=gorm dbname=gorm port=5432".
 //
-// As a precaution, do not attempt to perform complex or multiple
-// database operations — or rather perhaps, never load and attempt
-// to work with more than one loaded (.Open) database connection
-//
-// returns true on error
-func dbinik() (*gorm.DB, bool) {
-	db, err := gorm.Open(sqlite.Open(datasource), &gorm.Config{Logger: newLogger})
-	result := false
-	if err != nil {
-		result = true
+// "mysql" and "mariadb" expect a DSN such as
+// "user:pass@tcp(127.0.0.1:3306)/dbname?charset=utf8mb4&parseTime=True".
+func dialector(sys, source string) (gorm.Dialector, error) {
+	switch strings.ToLower(sys) {
+	case "", "sqlite", "sqlite3":
+		return sqlite.Open(source), nil
+	case "postgres", "postgresql", "pgx":
+		return postgres.Open(source), nil
+	case "mysql", "mariadb":
+		return mysql.Open(source), nil
 	}
-	return db, result
-}
-
-// closes the database and prints requested status on error.
-//
-// As a precaution, do not attempt to perform complex or multiple
-// database operations — or rather perhaps, never load and attempt
-// to work with more than one loaded (.Open) database connection
-//
-// returns true on error
-func iniC(format string, msg ...interface{}) (*gorm.DB, bool) {
-	return inik(true, format, msg...)
-}
-
-// keep error
-//
-// As a precaution, do not attempt to perform complex or multiple
-// database operations — or rather perhaps, never load and attempt
-// to work with more than one loaded (.Open) database connection
-//
-// returns true on error
-func iniK(format string, msg ...interface{}) (*gorm.DB, bool) {
-	return inik(false, format, msg...)
+	return nil, fmt.Errorf("session: unsupported data-system %q", sys)
 }
 
-// closes the database and prints requested status on error.
+// dbopen loads the database at source using the dialector for sys.
 //
-// As a precaution, do not attempt to perform complex or multiple
-// database operations — or rather perhaps, never load and attempt
-// to work with more than one loaded (.Open) database connection
-//
-// returns true on error
-func inik(closeOnError bool, format string, msg ...interface{}) (*gorm.DB, bool) {
-	db, e := dbinik()
-	result := false
-	if e {
-		if format != "" {
-			fmt.Printf("well then: "+format, msg...)
-		}
-		fmt.Printf("error: %v\n", e)
-		result = true
-		// if closeOnError {
-		// 	db.Close()
-		// }
+// This is called once per `GormStore`; the resulting connection pool
+// is shared thereafter.
+func dbopen(sys, source string) (*gorm.DB, error) {
+	dial, err := dialector(sys, source)
+	if err != nil {
+		return nil, err
 	}
-	return db, result
+	return gorm.Open(dial, &gorm.Config{Logger: newLogger})
 }
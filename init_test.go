@@ -0,0 +1,31 @@
+package session
+
+import "testing"
+
+func TestDialector(t *testing.T) {
+	tests := []struct {
+		sys  string
+		name string
+	}{
+		{"", "sqlite"},
+		{"sqlite3", "sqlite"},
+		{"SQLite", "sqlite"},
+		{"postgres", "postgres"},
+		{"pgx", "postgres"},
+		{"mysql", "mysql"},
+		{"mariadb", "mysql"},
+	}
+	for _, tt := range tests {
+		dial, err := dialector(tt.sys, "")
+		if err != nil {
+			t.Errorf("dialector(%q) error = %v", tt.sys, err)
+			continue
+		}
+		if got := dial.Name(); got != tt.name {
+			t.Errorf("dialector(%q) = %s, want %s", tt.sys, got, tt.name)
+		}
+	}
+	if _, err := dialector("oracle", ""); err == nil {
+		t.Error("dialector(oracle) did not fail")
+	}
+}
@@ -0,0 +1,226 @@
+package session
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	defaultPassMinLength = 5
+	defaultPassMaxLength = 1024
+	defaultNameMinLength = 5
+	defaultNameMaxLength = 27 // size of [users].[user]
+)
+
+type (
+	// Violation describes one rule of a `PasswordPolicy` or
+	// `UsernamePolicy` that a supplied value failed.
+	Violation struct {
+		Field  string `json:"field"` // "user" or "pass"
+		Rule   string `json:"rule"`  // e.g. "min-length"
+		Detail string `json:"detail"`
+	}
+	// PolicyError is returned when a username or password fails policy.
+	PolicyError struct {
+		Violations []Violation
+	}
+	// PasswordPolicy governs the passwords users may choose.
+	//
+	// Zero lengths use our defaults (5 and 1024).
+	PasswordPolicy struct {
+		MinLength     int
+		MaxLength     int
+		RequireUpper  bool
+		RequireLower  bool
+		RequireDigit  bool
+		RequireSymbol bool
+		// DisallowUsername refuses a password containing the username.
+		DisallowUsername bool
+		// Banned passwords (lower-case); see `LoadBanned`.
+		Banned map[string]struct{}
+	}
+	// UsernamePolicy governs the usernames users may choose and how
+	// supplied usernames are normalized (at registration and login).
+	//
+	// Zero lengths use our defaults (5 and 27).
+	UsernamePolicy struct {
+		MinLength int
+		MaxLength int
+		// Pattern, if set, must match the (normalized) username;
+		// otherwise any printable, non-space characters are allowed.
+		Pattern *regexp.Regexp
+		// Normalize applies unicode NFKC normalization.
+		Normalize bool
+		// FoldCase lower-cases usernames.
+		FoldCase bool
+	}
+)
+
+func (e *PolicyError) Error() string {
+	details := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		details[i] = v.Detail
+	}
+	return "session: " + strings.Join(details, "; ")
+}
+
+// orError returns a *PolicyError for violations or nil if there are none.
+func orError(violations []Violation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	return &PolicyError{Violations: violations}
+}
+
+// LoadBanned reads banned passwords from a local file; one per line.
+// Blank lines and lines starting with "#" are ignored.
+func (p *PasswordPolicy) LoadBanned(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if p.Banned == nil {
+		p.Banned = map[string]struct{}{}
+	}
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.Banned[strings.ToLower(line)] = struct{}{}
+	}
+	return scan.Err()
+}
+
+// Check returns the rules pass fails for the user name.
+func (p *PasswordPolicy) Check(name, pass string) []Violation {
+	var v []Violation
+	add := func(rule, format string, a ...interface{}) {
+		v = append(v, Violation{Field: "pass", Rule: rule, Detail: fmt.Sprintf(format, a...)})
+	}
+	min, max := p.MinLength, p.MaxLength
+	if min == 0 {
+		min = defaultPassMinLength
+	}
+	if max == 0 {
+		max = defaultPassMaxLength
+	}
+	n := utf8.RuneCountInString(pass)
+	if n < min {
+		add("min-length", "Pass should be >= %d chars.", min)
+	}
+	if n > max {
+		add("max-length", "Pass should be <= %d chars.", max)
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range pass {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		add("upper", "Pass requires an upper-case letter.")
+	}
+	if p.RequireLower && !lower {
+		add("lower", "Pass requires a lower-case letter.")
+	}
+	if p.RequireDigit && !digit {
+		add("digit", "Pass requires a digit.")
+	}
+	if p.RequireSymbol && !symbol {
+		add("symbol", "Pass requires a symbol.")
+	}
+	if _, banned := p.Banned[strings.ToLower(pass)]; banned {
+		add("banned", "Pass is too common.")
+	}
+	if p.DisallowUsername && name != "" && strings.Contains(strings.ToLower(pass), strings.ToLower(name)) {
+		add("username", "Pass must not contain the user name.")
+	}
+	return v
+}
+
+// Normal returns name as it is stored: trimmed and, per policy,
+// NFKC normalized and lower-cased.
+func (p *UsernamePolicy) Normal(name string) string {
+	name = strings.TrimSpace(name)
+	if p.Normalize {
+		name = norm.NFKC.String(name)
+	}
+	if p.FoldCase {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+// Check returns the rules the (normalized) name fails.
+func (p *UsernamePolicy) Check(name string) []Violation {
+	var v []Violation
+	add := func(rule, format string, a ...interface{}) {
+		v = append(v, Violation{Field: "user", Rule: rule, Detail: fmt.Sprintf(format, a...)})
+	}
+	min, max := p.MinLength, p.MaxLength
+	if min == 0 {
+		min = defaultNameMinLength
+	}
+	if max == 0 || max > defaultNameMaxLength {
+		max = defaultNameMaxLength
+	}
+	n := utf8.RuneCountInString(name)
+	if n < min {
+		add("min-length", "Name should be >= %d chars.", min)
+	}
+	if n > max {
+		add("max-length", "Name should be <= %d chars.", max)
+	}
+	if p.Pattern != nil {
+		if !p.Pattern.MatchString(name) {
+			add("charset", "Name contains characters that are not allowed.")
+		}
+	} else if strings.IndexFunc(name, func(r rune) bool { return !unicode.IsPrint(r) || unicode.IsSpace(r) }) != -1 {
+		add("charset", "Name contains characters that are not allowed.")
+	}
+	return v
+}
+
+// normalUserName applies our service's `UsernamePolicy` normalization.
+func normalUserName(name string) string {
+	if service == nil {
+		return name
+	}
+	return service.UsernamePolicy.Normal(name)
+}
+
+// checkPassword applies our service's `PasswordPolicy`.
+func checkPassword(name, pass string) error {
+	p := PasswordPolicy{}
+	if service != nil {
+		p = service.PasswordPolicy
+	}
+	return orError(p.Check(name, pass))
+}
+
+// checkRegistration applies our service's `UsernamePolicy` and
+// `PasswordPolicy` to a (normalized) name and pass.
+func checkRegistration(name, pass string) error {
+	p, n := PasswordPolicy{}, UsernamePolicy{}
+	if service != nil {
+		p, n = service.PasswordPolicy, service.UsernamePolicy
+	}
+	return orError(append(n.Check(name), p.Check(name, pass)...))
+}
@@ -0,0 +1,157 @@
+package session
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// rules returns the Rule of each violation.
+func rules(violations []Violation) []string {
+	var list []string
+	for _, v := range violations {
+		list = append(list, v.Rule)
+	}
+	return list
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	strict := PasswordPolicy{
+		MinLength: 8, MaxLength: 16,
+		RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true,
+		DisallowUsername: true,
+		Banned:           map[string]struct{}{"passw0rd!pass": {}},
+	}
+	tests := []struct {
+		name   string
+		policy PasswordPolicy
+		pass   string
+		want   []string
+	}{
+		{"default ok", PasswordPolicy{}, "abcde", nil},
+		{"default short", PasswordPolicy{}, "abcd", []string{"min-length"}},
+		{"strict ok", strict, "Tr0ub4dor&3", nil},
+		{"strict short", strict, "Ab1!", []string{"min-length"}},
+		{"strict long", strict, "Tr0ub4dor&3Tr0ub4dor&3", []string{"max-length"}},
+		{"strict classes", strict, "abcdefgh", []string{"upper", "digit", "symbol"}},
+		{"strict lower", strict, "ABCDEFG1!", []string{"lower"}},
+		{"banned", strict, "PASSW0RD!pass", []string{"banned"}},
+		{"username", strict, "xAdmin1!x", []string{"username"}},
+		{"runes", PasswordPolicy{MinLength: 3, MaxLength: 3}, "äöü", nil},
+	}
+	for _, tt := range tests {
+		if got := rules(tt.policy.Check("admin1", tt.pass)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Check(%q) = %v, want %v", tt.name, tt.pass, got, tt.want)
+		}
+	}
+}
+
+func TestUsernamePolicyCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy UsernamePolicy
+		user   string
+		want   []string
+	}{
+		{"default ok", UsernamePolicy{}, "admin1", nil},
+		{"default short", UsernamePolicy{}, "adm", []string{"min-length"}},
+		{"default long", UsernamePolicy{}, "a123456789012345678901234567", []string{"max-length"}},
+		{"max capped", UsernamePolicy{MaxLength: 100}, "a123456789012345678901234567", []string{"max-length"}},
+		{"space", UsernamePolicy{}, "admin 1", []string{"charset"}},
+		{"control", UsernamePolicy{}, "admin\x001", []string{"charset"}},
+		{"pattern ok", UsernamePolicy{Pattern: regexp.MustCompile(`^[a-z0-9]+$`)}, "admin1", nil},
+		{"pattern", UsernamePolicy{Pattern: regexp.MustCompile(`^[a-z0-9]+$`)}, "Admin1", []string{"charset"}},
+	}
+	for _, tt := range tests {
+		if got := rules(tt.policy.Check(tt.user)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Check(%q) = %v, want %v", tt.name, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestUsernamePolicyNormal(t *testing.T) {
+	tests := []struct {
+		policy UsernamePolicy
+		in     string
+		want   string
+	}{
+		{UsernamePolicy{}, " Admin1 ", "Admin1"},
+		{UsernamePolicy{}, "ａｄｍｉｎ１", "ａｄｍｉｎ１"},
+		{UsernamePolicy{Normalize: true}, "ａｄｍｉｎ１", "admin1"},
+		{UsernamePolicy{FoldCase: true}, "Admin1", "admin1"},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.Normal(tt.in); got != tt.want {
+			t.Errorf("%+v: Normal(%q) = %q, want %q", tt.policy, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLoadBanned(t *testing.T) {
+	f, err := ioutil.TempFile("", "banned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# common passwords\n\nPassword1\n  letmein  \n")
+	f.Close()
+	p := PasswordPolicy{}
+	if err := p.LoadBanned(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Banned) != 2 {
+		t.Errorf("Banned = %v", p.Banned)
+	}
+	if got := rules(p.Check("admin1", "LETMEIN")); !reflect.DeepEqual(got, []string{"banned"}) {
+		t.Errorf("Check(LETMEIN) = %v", got)
+	}
+	if err := p.LoadBanned(f.Name() + ".missing"); err == nil {
+		t.Error("LoadBanned of a missing file")
+	}
+}
+
+func TestServeRegisterPolicy(t *testing.T) {
+	c := newTestClient(t, func(s *Service) {
+		s.PasswordPolicy = PasswordPolicy{RequireDigit: true}
+	})
+	defer c.close()
+	_, j := c.do(http.MethodGet, "/register/", url.Values{"user": {"adm"}, "pass": {"password"}})
+	violations, _ := j.Data.(map[string]interface{})["violations"].([]interface{})
+	if j.Status || len(violations) != 2 {
+		t.Fatalf("register: %+v", j)
+	}
+	if v, _ := violations[0].(map[string]interface{}); v["field"] != "user" || v["rule"] != "min-length" {
+		t.Errorf("violation %v", v)
+	}
+	if _, j := c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}}); !j.Status {
+		t.Errorf("register: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}}); j.Status {
+		t.Errorf("registered a name twice: %+v", j)
+	}
+}
+
+func TestByNameBeforeNormalize(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	user := url.Values{"user": {"Admin1"}, "pass": {"password1"}}
+	c.do(http.MethodGet, "/register/", user)
+	c.do(http.MethodGet, "/logout/", nil)
+
+	// normalizing is turned on after the user was stored.
+	c.svc.UsernamePolicy.FoldCase = true
+	if _, j := c.do(http.MethodGet, "/login/", user); !j.Status {
+		t.Errorf("login by the stored name: %+v", j)
+	}
+	u := User{}
+	if !u.ByName("Admin1") || u.Name != "Admin1" {
+		t.Errorf("ByName = %+v", u)
+	}
+	if u.ByName("ADMIN1") {
+		t.Error("found by a name neither stored nor normal")
+	}
+}
@@ -0,0 +1,113 @@
+package session
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRecoveryCount = 10
+	recoveryAlphabet     = "abcdefghjkmnpqrstuvwxyz23456789"
+	recoveryLength       = 10
+)
+
+// RecoveryCode is a single-use code which may be supplied in place of
+// a TOTP code.  Only its hash is stored, as a PHC string (see
+// `NewPasswordHash`) so that `OverrideCrypto` does not invalidate it.
+type RecoveryCode struct {
+	ID      int64     `gorm:"auto_increment;unique_index;primary_key;column:id"`
+	UserID  int64     `gorm:"not null;column:user_id"` // [users].[id]
+	Hash    string    `gorm:"size:432;column:hash"`
+	Created time.Time `gorm:"not null;column:created"`
+}
+
+// TableName Set RecoveryCode's table name to be `recovery_codes`
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// newRecoveryCode returns a random code formatted as "xxxxx-xxxxx".
+func newRecoveryCode() string {
+	b := make([]byte, recoveryLength)
+	max := big.NewInt(int64(len(recoveryAlphabet)))
+	for i := range b {
+		n, _ := rand.Int(rand.Reader, max)
+		b[i] = recoveryAlphabet[n.Int64()]
+	}
+	return string(b[:recoveryLength/2]) + "-" + string(b[recoveryLength/2:])
+}
+
+// normalRecoveryCode strips case, spaces and dashes from user input.
+func normalRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// GenerateRecoveryCodes creates a new batch of n recovery codes for the
+// user (10 if n <= 0) and returns them.  Any previous codes are invalidated.
+//
+// The returned codes can not be retrieved again.
+func (u *User) GenerateRecoveryCodes(n int) ([]string, error) {
+	if u.ID == 0 {
+		return nil, ErrNotFound
+	}
+	if n <= 0 {
+		n = defaultRecoveryCount
+	}
+	t := time.Now()
+	codes := make([]string, n)
+	rows := make([]RecoveryCode, n)
+	for i := range codes {
+		codes[i] = newRecoveryCode()
+		rows[i] = RecoveryCode{
+			UserID:  u.ID,
+			Hash:    NewPasswordHash(normalRecoveryCode(codes[i])),
+			Created: t,
+		}
+	}
+	if err := storage().RecoveryReplace(u.ID, rows); err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RedeemRecoveryCode checks code against the user's recovery codes and,
+// if it matches, deletes it so that it can not be used again.
+func (u *User) RedeemRecoveryCode(code string) bool {
+	code = normalRecoveryCode(code)
+	if u.ID == 0 || len(code) != recoveryLength {
+		return false
+	}
+	rows, err := storage().RecoveryByUser(u.ID)
+	if err != nil {
+		return false
+	}
+	for _, x := range rows {
+		if x.matches(code) {
+			return storage().RecoveryDelete(x.ID) == nil
+		}
+	}
+	return false
+}
+
+// matches checks a normalized code against x.
+func (x *RecoveryCode) matches(code string) bool {
+	ok, _ := VerifyPasswordHash(code, x.Hash)
+	return ok
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes.
+func (u *User) RecoveryCodesLeft() int {
+	rows, _ := storage().RecoveryByUser(u.ID)
+	return len(rows)
+}
+
+// EnsureTableRecoveryCodes creates table [recovery_codes] if not exist.
+func EnsureTableRecoveryCodes() {
+	if err := storage().EnsureRecoveryCodes(); err != nil {
+		fmt.Printf("error(ensure-table-recovery-codes): %v\n", err)
+	}
+}
@@ -0,0 +1,87 @@
+package session
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryCodes(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}})
+	u := User{}
+	u.ByName("admin1")
+
+	codes, err := u.GenerateRecoveryCodes(3)
+	if err != nil || len(codes) != 3 {
+		t.Fatalf("GenerateRecoveryCodes = %v, %v", codes, err)
+	}
+	for _, x := range c.svc.Store.(*memStore).recovery {
+		for _, code := range codes {
+			if strings.Contains(x.Hash, normalRecoveryCode(code)) {
+				t.Errorf("code %s stored in clear", code)
+			}
+		}
+	}
+	if u.RedeemRecoveryCode("aaaaa-aaaaa") {
+		t.Error("redeemed a code not issued")
+	}
+	// input is forgiving of case, spaces and dashes.
+	if !u.RedeemRecoveryCode(" " + strings.ToUpper(strings.Replace(codes[0], "-", " ", 1))) {
+		t.Errorf("did not redeem %s", codes[0])
+	}
+	if u.RedeemRecoveryCode(codes[0]) {
+		t.Error("redeemed a code twice")
+	}
+	if n := u.RecoveryCodesLeft(); n != 2 {
+		t.Errorf("RecoveryCodesLeft = %d, want 2", n)
+	}
+
+	fresh, _ := u.GenerateRecoveryCodes(0)
+	if len(fresh) != defaultRecoveryCount {
+		t.Errorf("generated %d codes, want %d", len(fresh), defaultRecoveryCount)
+	}
+	if u.RedeemRecoveryCode(codes[1]) {
+		t.Error("redeemed a code of a replaced batch")
+	}
+}
+
+func TestServeLoginRecovery(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+	c.do(http.MethodGet, "/register/", user)
+	_, j := c.do(http.MethodGet, "/mfa/enroll/", nil)
+	secret, _ := j.Data.(map[string]interface{})["secret"].(string)
+	_, j = c.do(http.MethodGet, "/mfa/confirm/", url.Values{"code": {totpNow(t, secret, 0)}})
+	codes, _ := j.Data.(map[string]interface{})["recovery"].([]interface{})
+	if !j.Status || len(codes) != defaultRecoveryCount {
+		t.Fatalf("confirm did not serve recovery codes: %+v", j)
+	}
+	code, _ := codes[0].(string)
+	c.do(http.MethodGet, "/logout/", nil)
+
+	c.do(http.MethodGet, "/login/", user)
+	if _, j := c.do(http.MethodGet, "/login/recovery/", url.Values{"code": {"aaaaa-aaaaa"}}); j.Status {
+		t.Errorf("wrong recovery code: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/login/recovery/", url.Values{"code": {code}}); !j.Status {
+		t.Fatalf("login/recovery: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/stat/", nil); !j.Status {
+		t.Errorf("stat after login/recovery: %+v", j)
+	}
+
+	if _, j := c.do(http.MethodGet, "/mfa/recovery/", url.Values{"pass": {"password2"}}); j.Status {
+		t.Errorf("mfa/recovery with a wrong password: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/mfa/recovery/", url.Values{"pass": {"password1"}}); !j.Status {
+		t.Errorf("mfa/recovery: %+v", j)
+	}
+	c.do(http.MethodGet, "/mfa/disable/", url.Values{"pass": {"password1"}})
+	if n := len(c.svc.Store.(*memStore).recovery); n != 0 {
+		t.Errorf("%d recovery codes left after disable", n)
+	}
+}
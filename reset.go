@@ -0,0 +1,173 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultResetUserLimit   = 3
+	defaultResetClientLimit = 10
+	// resetWindow is how long reset requests are counted.
+	resetWindow = time.Hour
+)
+
+var (
+	// ErrResetToken is returned for a password reset token that
+	// does not exist, was already used or has expired.
+	ErrResetToken = errors.New("session: invalid or expired reset token")
+	// ErrNoResetSender is returned when `Service.ResetSender` is not set.
+	ErrNoResetSender = errors.New("session: no reset sender configured")
+)
+
+// ResetSenderHandler delivers a password reset token to a user,
+// e.g. by mail.  The token is only ever known to this callback;
+// the database stores its hash.
+type ResetSenderHandler func(user User, token string) error
+
+// PasswordReset is a single-use token allowing a user to set a new
+// password without knowing the current one.
+type PasswordReset struct {
+	ID      int64     `gorm:"auto_increment;unique_index;primary_key;column:id"`
+	UserID  int64     `gorm:"not null;column:user_id"` // [users].[id]
+	Hash    string    `gorm:"size:64;not null;column:hash"`
+	Created time.Time `gorm:"not null;column:created"`
+	Expires time.Time `gorm:"not null;column:expires"`
+}
+
+// TableName Set PasswordReset's table name to be `password_resets`
+func (PasswordReset) TableName() string {
+	return "password_resets"
+}
+
+// IsValid returns true if the reset has not yet expired.
+func (r *PasswordReset) IsValid() bool {
+	if r.ID == 0 {
+		return false
+	}
+	return time.Now().Before(r.Expires)
+}
+
+// IssueReset creates a password reset for the user and returns its token.
+// The reset expires after `Service.ResetExpiry`.
+func (u *User) IssueReset() (string, error) {
+	if u.ID == 0 {
+		return "", ErrNotFound
+	}
+	expiry := defaultResetExpiry
+	if service != nil && service.ResetExpiry > 0 {
+		expiry = service.ResetExpiry
+	}
+	token := NewToken(defaultTokenSize)
+	t := time.Now()
+	r := PasswordReset{UserID: u.ID, Hash: hashToken(token), Created: t, Expires: t.Add(expiry)}
+	if err := storage().ResetCreate(&r); err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return "", err
+	}
+	return token, nil
+}
+
+// SendPasswordReset issues a password reset for the user matching name
+// and hands its token to `Service.ResetSender`.
+//
+// Returns ErrNotFound if there is no such user; callers serving a client
+// should not reveal this, neither by their answer nor by its timing
+// ("/reset/" calls this in the background).
+func (s *Service) SendPasswordReset(name string) error {
+	if s.ResetSender == nil {
+		return ErrNoResetSender
+	}
+	u := User{}
+	if !u.ByName(name) {
+		return ErrNotFound
+	}
+	token, err := u.IssueReset()
+	if err != nil {
+		return err
+	}
+	return s.ResetSender(u, token)
+}
+
+// resetAllowed counts a reset request from client for name and returns
+// false once either has passed its limit (see `Service.ResetUserLimit`)
+// within the last hour.  Unknown names are counted alike.
+func (s *Service) resetAllowed(client, name string) bool {
+	allowed := true
+	limits := []int{
+		lockoutInt(s.ResetClientLimit, defaultResetClientLimit),
+		lockoutInt(s.ResetUserLimit, defaultResetUserLimit),
+	}
+	for i, key := range []string{"reset:" + throttleClientKey(client), "reset:" + throttleNameKey(name)} {
+		if limits[i] < 0 {
+			continue
+		}
+		if err := storage().ThrottleFail(key, time.Now().Add(-resetWindow)); err != nil {
+			fmt.Printf("error(reset-limit): %v\n", err)
+			continue
+		}
+		if t, err := storage().ThrottleByKey(key); err == nil && t.Failures > limits[i] {
+			allowed = false
+		}
+	}
+	return allowed
+}
+
+// ValidateResetToken returns the user a valid (unexpired, unused)
+// reset token was issued to.
+func ValidateResetToken(token string) (User, bool) {
+	u := User{}
+	if token == "" {
+		return u, false
+	}
+	r, err := storage().ResetByHash(hashToken(token))
+	if err != nil || !r.IsValid() {
+		return u, false
+	}
+	return u, u.ByID(r.UserID)
+}
+
+// ConsumeResetToken sets a new password for the user the token was
+// issued to.  The token (and any other reset issued to the user)
+// can not be used again.
+//
+// All of the user's sessions are revoked, as they may be held by
+// whoever the password is reset against, and its failed logins are
+// cleared so that the new password is not locked out.
+//
+// Returns a *PolicyError if newPass fails `Service.PasswordPolicy`;
+// the token remains usable in that case.
+func ConsumeResetToken(token, newPass string) (User, error) {
+	u, ok := ValidateResetToken(token)
+	if !ok {
+		return u, ErrResetToken
+	}
+	if err := checkPassword(u.Name, newPass); err != nil {
+		return u, err
+	}
+	r, err := storage().ResetTake(hashToken(token))
+	if err != nil || !r.IsValid() || r.UserID != u.ID {
+		return u, ErrResetToken
+	}
+	if err := storage().ResetDeleteByUser(u.ID); err != nil {
+		return u, err
+	}
+	if err := u.setPassword(newPass); err != nil {
+		return u, err
+	}
+	if _, err := u.RevokeAllSessions(nil); err != nil {
+		fmt.Printf("error(reset-revoke): %v\n", err)
+	}
+	if err := storage().ThrottleClear(throttleUserKey(&u)); err != nil {
+		fmt.Printf("error(reset-throttle): %v\n", err)
+	}
+	return u, nil
+}
+
+// EnsureTableResets creates table [password_resets] if not exist.
+func EnsureTableResets() {
+	if err := storage().EnsureResets(); err != nil {
+		fmt.Printf("error(ensure-table-resets): %v\n", err)
+	}
+}
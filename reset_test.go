@@ -0,0 +1,132 @@
+package session
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPasswordReset(t *testing.T) {
+	tokens := make(chan string, 1)
+	c := newTestClient(t, func(s *Service) {
+		s.ResetSender = func(u User, token string) error {
+			tokens <- token
+			return nil
+		}
+	})
+	defer c.close()
+	c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}})
+	c.do(http.MethodGet, "/logout/", nil)
+
+	_, unknown := c.do(http.MethodGet, "/reset/", url.Values{"user": {"nobody1"}})
+	_, known := c.do(http.MethodGet, "/reset/", url.Values{"user": {"admin1"}})
+	if !known.Status || unknown != known {
+		t.Errorf("reset responses differ: %+v, %+v", unknown, known)
+	}
+	var token string
+	select {
+	case token = <-tokens:
+	case <-time.After(time.Second):
+		t.Fatal("no token sent")
+	}
+	if u, ok := ValidateResetToken(token); !ok || u.Name != "admin1" {
+		t.Errorf("ValidateResetToken = %+v, %v", u, ok)
+	}
+
+	confirm := func(token, pass string) LogonModel {
+		_, j := c.do(http.MethodGet, "/reset/confirm/", url.Values{"token": {token}, "newpass": {pass}})
+		return j
+	}
+	if j := confirm(token+"x", "password2"); j.Status {
+		t.Errorf("wrong token: %+v", j)
+	}
+	if j := confirm(token, "abc"); j.Status {
+		t.Errorf("short password: %+v", j)
+	}
+	if j := confirm(token, "password2"); !j.Status {
+		t.Fatalf("confirm: %+v", j)
+	}
+	if j := confirm(token, "password3"); j.Status {
+		t.Errorf("token used twice: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/login/", url.Values{"user": {"admin1"}, "pass": {"password2"}}); !j.Status {
+		t.Errorf("login with the new password: %+v", j)
+	}
+}
+
+func TestPasswordResetExpired(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}})
+	u := User{}
+	u.ByName("admin1")
+	token, err := u.IssueReset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := c.svc.Store.(*memStore)
+	for k, r := range m.resets {
+		r.Expires = time.Now().Add(-time.Second)
+		m.resets[k] = r
+	}
+	if _, ok := ValidateResetToken(token); ok {
+		t.Error("expired token validated")
+	}
+	if _, err := ConsumeResetToken(token, "password2"); err != ErrResetToken {
+		t.Errorf("ConsumeResetToken = %v, want ErrResetToken", err)
+	}
+}
+
+func TestServeResetLimit(t *testing.T) {
+	c := newTestClient(t, func(s *Service) {
+		s.ResetSender = func(u User, token string) error { return nil }
+		s.ResetUserLimit = 2
+		s.ResetClientLimit = 3
+	})
+	defer c.close()
+	reset := func(name string) bool {
+		_, j := c.do(http.MethodGet, "/reset/", url.Values{"user": {name}})
+		return j.Status
+	}
+	if !reset("nobody1") || !reset("nobody1") {
+		t.Fatal("reset refused below the limit")
+	}
+	if reset("nobody1") {
+		t.Error("user limit not applied")
+	}
+	if reset("nobody2") {
+		t.Error("client limit not applied")
+	}
+}
+
+func TestPasswordResetRevokes(t *testing.T) {
+	c := newTestClient(t, func(s *Service) { s.LockoutUserThreshold = 2 })
+	defer c.close()
+	user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+	c.do(http.MethodGet, "/register/", user)
+	other := c.browser()
+	other.header.Set("User-Agent", "agent-b")
+	other.do(http.MethodGet, "/login/", user)
+	for i := 0; i < 2; i++ {
+		other.do(http.MethodGet, "/login/", url.Values{"user": {"admin1"}, "pass": {"wrong"}})
+	}
+
+	u := User{}
+	u.ByName("admin1")
+	token, err := u.IssueReset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConsumeResetToken(token, "password2"); err != nil {
+		t.Fatal(err)
+	}
+	for name, x := range map[string]*testClient{"current": c, "other": other} {
+		if _, j := x.do(http.MethodGet, "/stat/", nil); j.Status {
+			t.Errorf("%s session not revoked: %+v", name, j)
+		}
+	}
+	if _, j := c.do(http.MethodGet, "/login/", url.Values{"user": {"admin1"}, "pass": {"password2"}}); !j.Status {
+		t.Errorf("login after reset: %+v", j)
+	}
+}
@@ -1,13 +1,16 @@
 package session
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type (
@@ -32,9 +35,15 @@ type (
 	}
 	// FormSession will collect form data.
 	FormSession struct {
-		User string
-		Pass string
-		Keep string
+		User    string
+		Pass    string
+		Keep    string
+		Sess    string // `SessionInfo.ID` for the /sessions/ routes
+		Label   string // session label for "/sessions/name/"
+		NewPass string // new password for "/password/"
+		Token   string // password reset token for "/reset/confirm/"
+		Code    string // TOTP code for "/login/mfa/" and "/mfa/confirm/"
+		CSRF    string // CSRF token; see `CSRFToken`
 	}
 	// Service is not required with exception to this little demo ;)
 	Service struct {
@@ -48,16 +57,128 @@ type (
 		AdvanceOnKeepYear   int
 		AdvanceOnKeepMonth  int
 		AdvanceOnKeepDay    int
+		// CookieSameSite is the SameSite attribute of our cookies; not
+		// set if zero.  http.SameSiteNoneMode requires CookieSecure
+		// (see `Service.CheckConfig`).
+		CookieSameSite http.SameSite
+		// CookieDomain scopes our cookies to a domain and its
+		// subdomains (e.g. "example.com"); the host only if empty.
+		CookieDomain string
+		// CookiePath scopes our cookies to a path; "/" if empty.
+		// It must cover our routes ("/login/", "/stat/" ...).
+		CookiePath string
+		// CookiePrefix names our cookies with the `__Host-` prefix (or
+		// `__Secure-` given a CookieDomain or CookiePath) so that browsers
+		// enforce their attributes.  Only applies with CookieSecure.
+		CookiePrefix bool
 		// supply a uri-path token such as "/json/" to check.
 		// We supply a `KeySessionIsValid` for the responseHandler
 		// to utilize to handle the secure content manually.
 		URICheck []string
 		// Unlike URICheck, we'll abort a response for any URI
 		// path provided to this list if user is not logged in.
-		URIEnforce      []string
+		URIEnforce []string
+		// Stateless validates sessions for URICheck and URIEnforce from
+		// the claims of an AES-GCM encrypted `<host>_st` cookie rather than
+		// looking them up from the database on every request.
+		Stateless bool
+		// StatelessKeys are AES keys (16, 24 or 32 bytes) for Stateless.
+		// The first encrypts while all of them decrypt.
+		StatelessKeys [][]byte
+		// StatelessTTL is how long claims are trusted before the session
+		// is looked up again and the claims renewed (5m if zero), e.g.
+		// after logging out or changing the password.
+		StatelessTTL time.Duration
+		// In stateless mode, sessions on URIs matching URISensitive (and
+		// URICheck or URIEnforce) are also looked up so that revoked or
+		// logged out sessions are refused.
+		URISensitive    []string
 		VerboseCheck    bool
 		URIMatchHandler URIMatchHandler
 		URIAbortHandler URIAbortHandler
+		// CSRFMode enables CSRF protection of unsafe requests (POST, PUT,
+		// PATCH, DELETE ...) and limits our state changing routes such as
+		// "/login/" and "/logout/" to POST; see `CSRFToken`.
+		//
+		// Set this prior to `SetupService`.
+		CSRFMode CSRFMode
+		// CSRFExempt are regular expressions of URIs that are not
+		// CSRF checked (e.g. API routes authenticated otherwise).
+		CSRFExempt []string
+		// FlashDetail adds the detail of "/login/" and "/register/"
+		// responses as a flash message (see `AddFlash`) for pages that
+		// post to them and redirect.
+		FlashDetail bool
+		// RevokeOnPasswordChange expires all other sessions of a user
+		// when the password is changed through "/password/".
+		RevokeOnPasswordChange bool
+		// ResetSender delivers password reset tokens (e.g. by mail).
+		// "/reset/" is disabled while this is nil.
+		ResetSender ResetSenderHandler
+		// ResetExpiry is how long a password reset token is valid;
+		// one hour if zero.
+		ResetExpiry time.Duration
+		// ResetUserLimit is the number of "/reset/" requests answered
+		// for a user name within an hour (3 if zero, -1 disables).
+		ResetUserLimit int
+		// ResetClientLimit is as ResetUserLimit but counts requests
+		// from a client IP (10 if zero).
+		ResetClientLimit int
+		// PasswordPolicy is applied to new passwords.
+		PasswordPolicy PasswordPolicy
+		// UsernamePolicy is applied to new usernames and normalizes
+		// usernames supplied to login.
+		UsernamePolicy UsernamePolicy
+		// PasswordHasher creates new password hashes; argon2id if nil.
+		PasswordHasher PasswordHasher
+		// PasswordHashers verify existing hashes of other algorithms which
+		// are replaced using PasswordHasher on login.  If nil, argon2id,
+		// bcrypt, scrypt and PBKDF2 hashes are accepted.
+		PasswordHashers []PasswordHasher
+		// CookieKeys sign (HMAC-SHA256) the values of our cookies so
+		// that tampered cookies are rejected.  The first key signs while
+		// all of them verify; to rotate, prepend a new key and drop the
+		// last once its cookies have expired.  Cookies are not signed if
+		// empty, and enabling signing ends existing (unsigned) sessions.
+		CookieKeys [][]byte
+		// Peppers are application secrets by key ID.  Passwords are keyed
+		// with one (HMAC-SHA256) before hashing and the key ID is stored
+		// with the hash.  Keep retired peppers here until users have
+		// logged in (and been rehashed) with the current PepperID.
+		Peppers map[string][]byte
+		// PepperID names the pepper new hashes are created with;
+		// passwords are not peppered if empty.  It must be one of Peppers.
+		PepperID string
+		// GenericLoginFailure answers a failed "/login/" with the same
+		// detail whether the user does not exist or the password did not
+		// match so that usernames can not be enumerated.
+		GenericLoginFailure bool
+		// LockoutUserThreshold is the number of failed logins for a user
+		// after which further logins are refused for a time (doubling with
+		// each further failure).  Zero uses the default (5); negative disables.
+		LockoutUserThreshold int
+		// LockoutClientThreshold is as LockoutUserThreshold but counts
+		// failed logins from a client (IP).  Zero uses the default (20).
+		LockoutClientThreshold int
+		// LockoutBase is the first lockout period (30s if zero).
+		LockoutBase time.Duration
+		// LockoutMax limits the lockout period (1h if zero).
+		LockoutMax time.Duration
+		// MFAIssuer names the account in authenticator apps;
+		// `AppID` is used if empty.
+		MFAIssuer string
+		// MFAExpiry is how long a user has to supply a TOTP code
+		// after supplying a valid password; five minutes if zero.
+		MFAExpiry time.Duration
+		// JanitorGrace is how long an expired session is kept
+		// before `PurgeSessions` (or the janitor) deletes it.
+		JanitorGrace time.Duration
+		// JanitorReport receives the result of each janitor sweep.
+		JanitorReport JanitorReportHandler
+		// Store persists users and sessions.
+		// If nil, a `GormStore` is created on the data-source
+		// supplied to `SetupService` (or `SetDefaults`).
+		Store Store
 	}
 )
 
@@ -71,12 +192,19 @@ const (
 	actionLogout               = "logout"
 	actionRegister             = "register"
 	actionStatus               = "status"
-	actionUnregister           = "unregister" // not implemented yet
+	actionUnregister           = "unregister"
+	actionSessions             = "sessions"
+	actionPassword             = "password"
+	actionReset                = "reset"
+	actionMFA                  = "mfa"
+	actionCSRF                 = "csrf"
 	baseMatchFmt               = "^%s"
+	defaultLoginFailure        = "Invalid user or password."
 )
 
 var (
-	service *Service
+	defaultFormSession = FormSession{User: "user", Pass: "pass", Keep: "keep", Sess: "sess", Label: "label", NewPass: "newpass", Token: "token", Code: "code", CSRF: "csrf"}
+	service            *Service
 	// SessionConfiguration is our live configuration.
 	// It stores default form element names and a key that
 	// will be made available to all http.Request responses
@@ -103,7 +231,17 @@ func DefaultService() *Service {
 		URICheck:            []string{},
 		// this is identical to default uri-handler (set URIMatchHandler to nil for default)
 		VerboseCheck: false,
-		FormSession:  FormSession{User: "user", Pass: "pass", Keep: "keep"},
+		FormSession:  defaultFormSession,
+		// Normalize, FoldCase and DisallowUsername are opt-in so that
+		// existing users are not affected.
+		PasswordPolicy: PasswordPolicy{
+			MinLength: defaultPassMinLength,
+			MaxLength: defaultPassMaxLength,
+		},
+		UsernamePolicy: UsernamePolicy{
+			MinLength: defaultNameMinLength,
+			MaxLength: defaultNameMaxLength,
+		},
 	}
 }
 
@@ -136,20 +274,67 @@ func (s *Service) AddDate(value interface{}) time.Time {
 // GetFormSession gets form values from http.Request
 func GetFormSession(r *http.Request) FormSession {
 	return FormSession{
-		User: r.FormValue(service.User),
-		Pass: r.FormValue(service.Pass),
-		Keep: r.FormValue(service.Keep),
+		User:    r.FormValue(service.User),
+		Pass:    r.FormValue(service.Pass),
+		Keep:    r.FormValue(service.Keep),
+		Sess:    r.FormValue(service.Sess),
+		Label:   r.FormValue(service.Label),
+		NewPass: r.FormValue(service.NewPass),
+		Token:   r.FormValue(service.Token),
+		Code:    r.FormValue(service.Code),
+		CSRF:    r.FormValue(service.CSRF),
 	}
 }
+
+// withDefaults fills in empty form element names with our defaults.
+func (f FormSession) withDefaults() FormSession {
+	if f.User == "" {
+		f.User = defaultFormSession.User
+	}
+	if f.Pass == "" {
+		f.Pass = defaultFormSession.Pass
+	}
+	if f.Keep == "" {
+		f.Keep = defaultFormSession.Keep
+	}
+	if f.Sess == "" {
+		f.Sess = defaultFormSession.Sess
+	}
+	if f.Label == "" {
+		f.Label = defaultFormSession.Label
+	}
+	if f.NewPass == "" {
+		f.NewPass = defaultFormSession.NewPass
+	}
+	if f.Token == "" {
+		f.Token = defaultFormSession.Token
+	}
+	if f.Code == "" {
+		f.Code = defaultFormSession.Code
+	}
+	if f.CSRF == "" {
+		f.CSRF = defaultFormSession.CSRF
+	}
+	return f
+}
 func (f *FormSession) hasUser() bool { return f.User != "" }
 func (f *FormSession) hasPass() bool { return f.Pass != "" }
 func (f *FormSession) hasKeep() bool { return f.Keep != "" && (f.Keep == "true" || f.Keep == "1") }
+func (f *FormSession) sessID() int64 {
+	id, _ := strconv.ParseInt(f.Sess, 10, 64)
+	return id
+}
 
 // SetupService sets up session service.
+// It panics if value is misconfigured (see `Service.CheckConfig`).
 //
 // Set saltSize or hashSize to -1 to persist internal defaults.
 func SetupService(value *Service, engine *gin.Engine, dbsys, dbsrc string, saltSize, hashSize int) {
+	if err := value.CheckConfig(); err != nil {
+		panic(err)
+	}
 	service = value
+	service.FormSession = service.FormSession.withDefaults()
 	if engine != nil {
 
 		service.attachRoutesAndMiddleware(engine)
@@ -166,6 +351,38 @@ func SetupService(value *Service, engine *gin.Engine, dbsys, dbsrc string, saltS
 	SetDefaults(dbsys, dbsrc, saltSize, hashSize)
 }
 
+// CheckConfig returns an error for a Service that can not work, such as
+// stateless mode without a valid key.  `SetupService` panics with it.
+func (s *Service) CheckConfig() error {
+	if s.CookieSameSite == http.SameSiteNoneMode && !s.CookieSecure {
+		return errors.New("session: CookieSameSite None requires CookieSecure")
+	}
+	if s.CSRFMode == CSRFDoubleSubmit && len(s.CookieKeys) == 0 {
+		return errors.New("session: CSRFDoubleSubmit requires CookieKeys")
+	}
+	if s.PepperID != "" && len(s.Peppers[s.PepperID]) == 0 {
+		return fmt.Errorf("session: PepperID %q: %v", s.PepperID, ErrPepperKey)
+	}
+	if s.Stateless {
+		if err := checkStatelessKeys(s.StatelessKeys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetupServiceDB sets up session service on a database that was already
+// opened by the host application rather than on a separate data-source.
+//
+// prefix (which may be empty) is prepended to our table names, e.g. a prefix
+// of "auth_" stores to `auth_users` and `auth_sessions`.
+//
+// Set saltSize or hashSize to -1 to persist internal defaults.
+func SetupServiceDB(value *Service, engine *gin.Engine, db *gorm.DB, prefix string, saltSize, hashSize int) {
+	value.Store = NewGormStoreDB(db, prefix)
+	SetupService(value, engine, "", "", saltSize, hashSize)
+}
+
 // DefaultURIMatchHandler uses a simple regular expression to validate
 // wether or not the URI session is to be validated.
 func DefaultURIMatchHandler(uri, expression string) bool {
@@ -0,0 +1,34 @@
+package session
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	key := make([]byte, 32)
+	tests := []struct {
+		name      string
+		configure func(s *Service)
+		ok        bool
+	}{
+		{"default", func(s *Service) {}, true},
+		{"pepper", func(s *Service) { s.Peppers = map[string][]byte{"k1": key}; s.PepperID = "k1" }, true},
+		{"unknown pepper", func(s *Service) { s.Peppers = map[string][]byte{"k1": key}; s.PepperID = "k2" }, false},
+		{"no peppers", func(s *Service) { s.PepperID = "k1" }, false},
+		{"stateless", func(s *Service) { s.Stateless = true; s.StatelessKeys = [][]byte{key} }, true},
+		{"stateless without keys", func(s *Service) { s.Stateless = true }, false},
+		{"double submit", func(s *Service) { s.CSRFMode = CSRFDoubleSubmit; s.CookieKeys = [][]byte{key} }, true},
+		{"double submit without keys", func(s *Service) { s.CSRFMode = CSRFDoubleSubmit }, false},
+		{"samesite none", func(s *Service) { s.CookieScope("", "/", http.SameSiteNoneMode) }, true},
+		{"samesite none insecure", func(s *Service) { s.CookieSameSite = http.SameSiteNoneMode }, false},
+		{"samesite lax insecure", func(s *Service) { s.CookieSameSite = http.SameSiteLaxMode }, true},
+	}
+	for _, tt := range tests {
+		s := DefaultService()
+		tt.configure(s)
+		if err := s.CheckConfig(); (err == nil) != tt.ok {
+			t.Errorf("%s: CheckConfig = %v", tt.name, err)
+		}
+	}
+}
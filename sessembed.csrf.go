@@ -0,0 +1,200 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CSRFMode selects how `Service` protects against cross-site request forgery.
+type CSRFMode int
+
+const (
+	// CSRFOff performs no CSRF checks.
+	CSRFOff CSRFMode = iota
+	// CSRFSynchronizer keeps the token with the session's data (see
+	// `SessionData`); before login it is kept to the `<host>_csrf` cookie.
+	CSRFSynchronizer
+	// CSRFDoubleSubmit keeps the token to the `<host>_csrf` cookie only;
+	// the request must repeat it.  The token is a nonce with its HMAC,
+	// keyed by `Service.CookieKeys` (required), bound to the session
+	// hash so that a token planted from another session is refused.
+	CSRFDoubleSubmit
+)
+
+const (
+	// CSRFHeader is the request header that may carry the CSRF token
+	// (rather than the `FormSession.CSRF` form value).
+	CSRFHeader  = "X-CSRF-Token"
+	keyCSRF     = "_csrf"
+	csrfSize    = 32
+	csrfFailure = "CSRF token missing or invalid."
+)
+
+// CSRFToken returns the CSRF token of the requesting client, creating
+// one if need be; supply it to forms (`FormSession.CSRF`) or
+// the `X-CSRF-Token` header of unsafe requests.
+//
+// Call it before writing the response.  "/csrf/" serves the token as JSON.
+func CSRFToken(g *gin.Context) string {
+	if token := expectedCSRF(g); token != "" {
+		return token
+	}
+	token := newCSRF(csrfBinding(g))
+	if d, ok := GetSessionData(g); ok && service.CSRFMode == CSRFSynchronizer {
+		d.Set(keyCSRF, token)
+	} else {
+		SetCookieSessOnly(g, csrfCookieName(), token)
+	}
+	g.Set(keyCSRF, token)
+	return token
+}
+
+// renewCSRF issues a new CSRF token as sess is started (to "/login/"
+// or "/register/") so that a token known prior to login is not usable.
+//
+// Returns an empty string if CSRF protection is off.
+func (s *Service) renewCSRF(g *gin.Context, sess *Session) string {
+	if s.CSRFMode == CSRFOff {
+		return ""
+	}
+	token := newCSRF(sess.SessHash)
+	g.Set(keyCSRF, token)
+	if s.CSRFMode != CSRFSynchronizer {
+		SetCookieSessOnly(g, csrfCookieName(), token)
+		return token
+	}
+	if x, ok := g.Get(keySessionData); ok {
+		if d, ok := x.(*SessionData); ok && d.sessionID == sess.ID {
+			d.Set(keyCSRF, token) // saved with the rest of the request's data
+			return token
+		}
+	}
+	raw, _ := json.Marshal(token)
+	if err := storage().SessionUpdateData(sess.ID, func(data string) (string, error) {
+		return mergeSessionData(data, map[string]json.RawMessage{keyCSRF: raw}, map[string]bool{keyCSRF: true})
+	}); err != nil {
+		fmt.Printf("ERROR(csrf): %s\n", err.Error())
+	}
+	return token
+}
+
+func csrfCookieName() string {
+	return service.SessHost() + "_csrf"
+}
+
+// newCSRF returns a new token; in `CSRFDoubleSubmit` mode it is bound
+// to binding, the `Session.SessHash` of the session it is issued to (or
+// empty before login).
+func newCSRF(binding string) string {
+	nonce := NewToken(csrfSize)
+	if service.CSRFMode != CSRFDoubleSubmit || len(service.CookieKeys) == 0 {
+		return nonce
+	}
+	return nonce + "." + csrfMAC(service.CookieKeys[0], binding, nonce)
+}
+
+// csrfBound reports wether token was issued by `newCSRF` for binding
+// with any of `Service.CookieKeys`.
+func csrfBound(token, binding string) bool {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return false
+	}
+	nonce, mac := token[:i], token[i+1:]
+	for _, key := range service.CookieKeys {
+		if compareBytes([]byte(csrfMAC(key, binding, nonce)), []byte(mac)) {
+			return true
+		}
+	}
+	return false
+}
+
+// csrfMAC is a `cookieMAC` of nonce under a name no cookie can have
+// (':' is not allowed in cookie names) so that neither can stand in for
+// the other.
+func csrfMAC(key []byte, binding, nonce string) string {
+	return cookieMAC(key, keyCSRF+":"+binding, nonce)
+}
+
+// csrfBinding returns the hash of the requesting client's session ID
+// (see `newCSRF`), or an empty string if it has none.
+func csrfBinding(g *gin.Context) string {
+	sessid := getCookieValue(service.SessHost(), g)
+	if sessid == "" {
+		return ""
+	}
+	return hashToken(sessid)
+}
+
+// expectedCSRF returns the token the requesting client must submit,
+// or an empty string if it has none.
+func expectedCSRF(g *gin.Context) string {
+	if x, ok := g.Get(keyCSRF); ok {
+		token, _ := x.(string)
+		return token
+	}
+	if service.CSRFMode == CSRFSynchronizer {
+		if d, ok := GetSessionData(g); ok {
+			if token := d.GetString(keyCSRF); token != "" {
+				return token
+			}
+			// a new session; the pre-login (cookie) token is not carried over.
+			return ""
+		}
+	}
+	token := getCookieValue(csrfCookieName(), g)
+	if service.CSRFMode == CSRFDoubleSubmit && token != "" && !csrfBound(token, csrfBinding(g)) {
+		// issued to another (or no) session.
+		return ""
+	}
+	return token
+}
+
+// isSafeMethod reports wether method is not expected to change state.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// csrfMiddleware refuses unsafe requests (see `isSafeMethod`) that do
+// not carry the CSRF token of the client, with exception to URIs
+// matching `Service.CSRFExempt`.
+func (s *Service) csrfMiddleware(g *gin.Context) {
+	if s.CSRFMode == CSRFOff || isSafeMethod(g.Request.Method) {
+		g.Next()
+		return
+	}
+	if exempt, _ := s.isunsafe(g.Request.RequestURI, s.CSRFExempt...); exempt {
+		g.Next()
+		return
+	}
+	sent := g.GetHeader(CSRFHeader)
+	if sent == "" {
+		sent = g.Request.FormValue(s.FormSession.CSRF)
+	}
+	expected := expectedCSRF(g)
+	if sent == "" || expected == "" || !compareBytes([]byte(sent), []byte(expected)) {
+		g.AbortWithStatusJSON(http.StatusForbidden, LogonModel{Action: actionCSRF, Detail: csrfFailure, Status: false})
+		return
+	}
+	g.Next()
+}
+
+// serveCSRF serves the CSRF token of the requesting client
+// as `{status: true, data: {token: <token>}}`.
+func (s *Service) serveCSRF(g *gin.Context) {
+	j := LogonModel{Action: actionCSRF, Detail: "CSRF protection is off.", Status: false}
+	if s.CSRFMode != CSRFOff {
+		j.Detail = "CSRF token."
+		j.Status = true
+		j.Data = map[string]interface{}{"token": CSRFToken(g)}
+	}
+	g.JSON(http.StatusOK, j)
+}
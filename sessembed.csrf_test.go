@@ -0,0 +1,88 @@
+package session
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	for _, mode := range []CSRFMode{CSRFSynchronizer, CSRFDoubleSubmit} {
+		c := newTestClient(t, func(s *Service) {
+			s.CSRFMode = mode
+			s.CookieKeys = [][]byte{[]byte("cookie-key")}
+		})
+		user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+
+		if code, _ := c.do(http.MethodPost, "/register/", user); code != http.StatusForbidden {
+			t.Errorf("mode %d: no token answered %d", mode, code)
+		}
+		_, j := c.do(http.MethodGet, "/csrf/", nil)
+		token, _ := j.Data.(map[string]interface{})["token"].(string)
+		if token == "" {
+			t.Fatalf("mode %d: no token served: %+v", mode, j)
+		}
+		if code, _ := c.do(http.MethodGet, "/register/", user); code != http.StatusNotFound {
+			t.Errorf("mode %d: GET /register/ answered %d", mode, code)
+		}
+		c.header.Set(CSRFHeader, token+"x")
+		if code, _ := c.do(http.MethodPost, "/register/", user); code != http.StatusForbidden {
+			t.Errorf("mode %d: wrong token answered %d", mode, code)
+		}
+		c.header.Set(CSRFHeader, token)
+		_, j = c.do(http.MethodPost, "/register/", user)
+		renewed, _ := j.Data.(map[string]interface{})["csrf"].(string)
+		if !j.Status || renewed == "" || renewed == token {
+			t.Fatalf("mode %d: register: %+v", mode, j)
+		}
+		// the token known before login is not usable after it.
+		if code, _ := c.do(http.MethodPost, "/logout/", nil); code != http.StatusForbidden {
+			t.Errorf("mode %d: pre-login token answered %d", mode, code)
+		}
+		c.header.Del(CSRFHeader)
+		if code, j := c.do(http.MethodPost, "/logout/", url.Values{"csrf": {renewed}}); code != http.StatusOK || !j.Status {
+			t.Errorf("mode %d: logout with form token: %d %+v", mode, code, j)
+		}
+		c.close()
+	}
+}
+
+func TestCSRFDoubleSubmitBound(t *testing.T) {
+	c := newTestClient(t, func(s *Service) {
+		s.CSRFMode = CSRFDoubleSubmit
+		s.CookieKeys = [][]byte{[]byte("cookie-key")}
+	})
+	defer c.close()
+	token := func(x *testClient) string {
+		_, j := x.do(http.MethodGet, "/csrf/", nil)
+		token, _ := j.Data.(map[string]interface{})["token"].(string)
+		return token
+	}
+	register := func(x *testClient, name string) {
+		x.header.Set(CSRFHeader, token(x))
+		if _, j := x.do(http.MethodPost, "/register/", url.Values{"user": {name}, "pass": {"password1"}}); !j.Status {
+			t.Fatalf("register %s: %+v", name, j)
+		}
+	}
+	victim, attacker := c, c.browser()
+	register(victim, "admin1")
+	register(attacker, "admin2")
+
+	// the attacker plants its own (valid) cookie and token on the victim.
+	planted := token(attacker)
+	ck := attacker.cookies[c.svc.SessHost()+"_csrf"]
+	victim.cookies[ck.Name] = ck
+	victim.header.Set(CSRFHeader, planted)
+	if code, _ := victim.do(http.MethodPost, "/logout/", nil); code != http.StatusForbidden {
+		t.Errorf("token of another session answered %d", code)
+	}
+	// a new token is issued in place of the planted one.
+	own := token(victim)
+	if own == planted {
+		t.Fatal("planted token served")
+	}
+	victim.header.Set(CSRFHeader, own)
+	if code, j := victim.do(http.MethodPost, "/logout/", nil); code != http.StatusOK || !j.Status {
+		t.Errorf("logout: %d %+v", code, j)
+	}
+}
@@ -0,0 +1,80 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// defaultJanitorInterval is used by `StartJanitor` for an interval <= 0.
+const defaultJanitorInterval = time.Hour
+
+// JanitorReportHandler is called by the janitor after each sweep
+// with the number of rows purged (or the error encountered).
+type JanitorReportHandler func(purged int64, err error)
+
+// PurgeSessions deletes every session, password reset and MFA challenge
+// which expired more than `Service.JanitorGrace` ago along with login
+// throttles that have started over, and returns the number of rows deleted.
+func (s *Service) PurgeSessions() (int64, error) {
+	before := time.Now().Add(-s.JanitorGrace)
+	var total int64
+	for _, purge := range []func() (int64, error){
+		func() (int64, error) { return storage().SessionPurge(before) },
+		func() (int64, error) { return storage().ResetPurge(before) },
+		func() (int64, error) { return storage().ChallengePurge(before) },
+		func() (int64, error) { return storage().ThrottlePurge(time.Now().Add(-throttleDecay)) },
+	} {
+		n, err := purge()
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// StartJanitor starts a background routine which calls `PurgeSessions`
+// every interval (an hour if <= 0) until ctx is cancelled.
+//
+// Results are passed to `Service.JanitorReport`; if it is nil they are
+// printed to stderr when `Service.VerboseCheck` is set.
+//
+// The returned channel is closed once the janitor has stopped.
+func (s *Service) StartJanitor(ctx context.Context, interval time.Duration) <-chan struct{} {
+	if interval <= 0 {
+		interval = defaultJanitorInterval
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purged, err := s.PurgeSessions()
+				s.janitorReport(purged, err)
+			}
+		}
+	}()
+	return done
+}
+
+func (s *Service) janitorReport(purged int64, err error) {
+	if s.JanitorReport != nil {
+		s.JanitorReport(purged, err)
+		return
+	}
+	if !s.VerboseCheck {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "janitor: error purging sessions: %v\n", err)
+	} else {
+		fmt.Fprintf(os.Stderr, "janitor: purged %d expired row(s)\n", purged)
+	}
+}
@@ -0,0 +1,72 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPurgeSessions(t *testing.T) {
+	c := newTestClient(t, func(s *Service) { s.JanitorGrace = time.Hour })
+	defer c.close()
+	m := c.svc.Store.(*memStore)
+	old, now := time.Now().Add(-2*throttleDecay), time.Now()
+	m.SessionCreate(&Session{Expires: old})
+	m.SessionCreate(&Session{Expires: now.Add(-time.Minute)}) // within grace
+	m.SessionCreate(&Session{Expires: now.Add(time.Hour)})
+	m.ResetCreate(&PasswordReset{Expires: old})
+	m.ChallengeCreate(&Challenge{Expires: old})
+	m.throttles["cli:x"] = Throttle{Key: "cli:x", Failures: 3, Updated: old}
+	m.throttles["cli:y"] = Throttle{Key: "cli:y", Failures: 3, Updated: now}
+
+	if n, err := c.svc.PurgeSessions(); n != 4 || err != nil {
+		t.Errorf("PurgeSessions = %d, %v; want 4, nil", n, err)
+	}
+	if len(m.sessions) != 2 || len(m.resets) != 0 || len(m.challenges) != 0 || len(m.throttles) != 1 {
+		t.Errorf("left %d sessions, %d resets, %d challenges, %d throttles",
+			len(m.sessions), len(m.resets), len(m.challenges), len(m.throttles))
+	}
+}
+
+func TestStartJanitor(t *testing.T) {
+	reports := make(chan int64, 1)
+	c := newTestClient(t, func(s *Service) {
+		s.JanitorReport = func(purged int64, err error) {
+			select {
+			case reports <- purged:
+			default:
+			}
+		}
+	})
+	defer c.close()
+	c.svc.Store.SessionCreate(&Session{Expires: time.Now().Add(-time.Hour)})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := c.svc.StartJanitor(ctx, time.Millisecond)
+	select {
+	case n := <-reports:
+		if n != 1 {
+			t.Errorf("janitor purged %d, want 1", n)
+		}
+	case <-time.After(time.Second):
+		t.Error("janitor did not report")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("janitor did not stop")
+	}
+}
+
+func TestStartJanitorInterval(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := c.svc.StartJanitor(ctx, 0) // must not panic
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("janitor did not stop")
+	}
+}
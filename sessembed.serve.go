@@ -1,6 +1,7 @@
 package session
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -19,14 +20,47 @@ func (s *Service) SessHost() string {
 }
 
 // attachRoutesAndMiddleware is called to connect gin.Engine to middleware and
-// /logout/, /login/, /register/ and /stat/ URI.
+// /logout/, /logout/all/, /login/, /login/mfa/, /login/recovery/, /register/,
+// /unregister/, /password/, /reset/, /mfa/, /stat/, /csrf/ and /sessions/ URI.
 func (s *Service) attachRoutesAndMiddleware(engine *gin.Engine) {
 	// fmt.Println("--> LOGON SESSIONS SUPPORTED")
 	engine.Use(s.sessMiddleware)
-	engine.Any("/logout/", s.serveLogout)
-	engine.Any("/login/", s.serveLogin)
-	engine.Any("/register/", s.serveRegister)
+	// routes changing state answer any method unless CSRF protected.
+	mutate := engine.Any
+	if s.CSRFMode != CSRFOff {
+		engine.Use(s.csrfMiddleware)
+		mutate = engine.POST
+	}
+	mutate("/logout/", s.serveLogout)
+	mutate("/logout/all/", s.serveLogoutAll)
+	mutate("/login/", s.serveLogin)
+	mutate("/login/mfa/", s.serveLoginMFA)
+	mutate("/login/recovery/", s.serveLoginRecovery)
+	mutate("/register/", s.serveRegister)
+	mutate("/unregister/", s.serveUnregister)
+	mutate("/password/", s.servePassword)
+	mutate("/reset/", s.serveReset)
+	mutate("/reset/confirm/", s.serveResetConfirm)
+	mutate("/mfa/enroll/", s.serveMFAEnroll)
+	mutate("/mfa/confirm/", s.serveMFAConfirm)
+	mutate("/mfa/disable/", s.serveMFADisable)
+	mutate("/mfa/recovery/", s.serveMFARecovery)
 	engine.Any("/stat/", s.serveUserStatus)
+	engine.Any("/csrf/", s.serveCSRF)
+	engine.Any("/sessions/", s.serveSessions)
+	mutate("/sessions/name/", s.serveSessionName)
+	mutate("/sessions/revoke/", s.serveSessionRevoke)
+}
+
+// currentUser returns the valid session of the requesting client
+// and the user that owns it.
+func (s *Service) currentUser(g *gin.Context) (Session, User, bool) {
+	sess, success := QueryCookie(s.SessHost(), g)
+	if !success || !sess.IsValid() {
+		return sess, User{}, false
+	}
+	u, success := sess.GetUser()
+	return sess, u, success
 }
 
 func (s *Service) sessMiddleware(g *gin.Context) {
@@ -47,7 +81,11 @@ func (s *Service) sessMiddleware(g *gin.Context) {
 	g.Set(s.KeySessionIsChecked, lookup)
 
 	if lookup {
-		issecure := QueryCookieValidate(s.SessHost(), g)
+		if s.Stateless {
+			issecure = s.statelessValid(g)
+		} else {
+			issecure = QueryCookieValidate(s.SessHost(), g)
+		}
 		g.Set(s.KeySessionIsValid, issecure)
 	}
 	if enforce && !issecure && s.URIAbortHandler != nil { // abort response.
@@ -60,7 +98,8 @@ func (s *Service) sessMiddleware(g *gin.Context) {
 	}
 	// a flag to check on the status in our actual handler.
 	// use `g.Get(<Key>)` from responseHandler
-	g.Next() // (calling this probably isn't necessary)
+	g.Next()
+	saveSessionData(g) // changed by the handler(s)?
 }
 
 const tfmt = "2006-01-02 03:04 PM"
@@ -91,6 +130,7 @@ func (s *Service) serveUserStatus(g *gin.Context) {
 				sess.Refresh(true)
 				SetCookieExpires(g, sh, sess.SessID, sess.Expires)
 			}
+			setClaimsCookie(g, sh, &sess) // renew the claims' TTL
 			g.JSON(http.StatusOK, &LogonModel{Action: actionStatus, Detail: "found", Status: true, Data: map[string]interface{}{"user": u.Name, "created": sess.Created, "expires": sess.Expires}})
 		} else {
 			g.JSON(http.StatusOK, &LogonModel{Action: actionStatus, Detail: "exists", Status: false})
@@ -107,6 +147,7 @@ func (s *Service) serveLogout(g *gin.Context) {
 	if success {
 		// fmt.Printf("  ==> CLIENT COOKIE EXISTS; USER=%d\n", sess.UserID)
 		SetCookieDestroy(g, sh)
+		destroyClaimsCookie(g, sh)
 		if time.Now().Before(sess.Expires) {
 			// fmt.Printf("  --> NOT EXPIRED; USER=%d\n", sess.UserID)
 			g.JSON(http.StatusOK, &LogonModel{Action: actionLogout, Detail: "Session exists; logged out.", Status: true})
@@ -123,6 +164,33 @@ func (s *Service) serveLogout(g *gin.Context) {
 	}
 }
 
+// serveLogoutAll revokes every session of the logged in user with
+// exception to the session making the request.
+func (s *Service) serveLogoutAll(g *gin.Context) {
+	j := LogonModel{Action: actionLogout, Detail: "Not logged in.", Status: false}
+	if sess, u, ok := s.currentUser(g); ok {
+		if n, err := u.RevokeAllSessions(&sess); err != nil {
+			j.Detail = "Failed to revoke sessions."
+		} else {
+			j.Detail = "Other sessions logged out."
+			j.Status = true
+			j.Data = map[string]interface{}{"revoked": n}
+		}
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// serveLogin validates `FormSession.User` and `FormSession.Pass`.
+//
+// Failed logins are counted per user and per client; once either passes
+// its threshold, logins are refused for a time without checking the
+// password (see `Service.LockoutUserThreshold`).
+//
+// If the user has TOTP enabled, no session is created; a pending
+// challenge is issued instead (`{status: false, data: {mfa: true}}`)
+// which is completed through "/login/mfa/".  The user's failed logins
+// are only cleared once the code is supplied and wrong codes count as
+// failed logins.
 func (s *Service) serveLogin(g *gin.Context) {
 
 	// fmt.Println("==> LOGIN REQUEST")
@@ -130,93 +198,449 @@ func (s *Service) serveLogin(g *gin.Context) {
 	form := GetFormSession(g.Request)
 
 	j := LogonModel{Action: actionLogin, Detail: "session creation failed.", Status: false}
-	sh := s.SessHost()
 
+	cli := getClientString(g)
 	u := User{}
-	if !u.ByName(form.User) {
+	found := u.ByName(form.User)
+	if !found {
+		u = User{Name: form.User} // throttled by name
+	}
+
+	if until, locked := s.LoginLocked(cli, &u); locked {
+
+		lockedOut(&j, until)
+
+	} else if !found {
 
 		// println("  --> USER NOT FOUND!")
-		j.Detail = "No user record."
+		if form.hasPass() {
+			dummyVerify(form.Pass) // take as long as a wrong password
+		}
+		s.LoginFailed(cli, &u)
+		j.Detail = s.loginFailure("No user record.")
 		j.Status = false
 
-	} else { // We have a valid user;
-
-		sess, success := u.UserSession(sh, g) // check for a existing session
-
-		if success { // user exists + session exists
-			if form.hasPass() {
-				if u.ValidatePassword(form.Pass) {
-					sess.Refresh(false)
-					sess.KeepAlive = form.hasKeep()
-					sess.Save()
-					sess.SetBrowserCookieFromSession(g, u.Name, sh)
-					j.Detail = "Logged in."
-					j.Status = true
-					j.Data = map[string]interface{}{"user": u.Name, "created": sess.Created, "expires": sess.Expires}
-				} else {
-					// fmt.Println("  ==> PW:FAIL")
-					j.Detail = "Password did not match."
-					j.Status = true
-				}
-			}
-		} else { // user exists + no session exists
-
-			if ok, ss := u.CreateSession(g, sh, form.hasKeep()); ok {
-				ss.SetBrowserCookieFromSession(g, u.Name, sh)
-				j.Detail = "Logged in."
-				j.Status = true
-				j.Data = map[string]interface{}{"user": u.Name, "created": sess.Created, "expires": sess.Expires}
-			} else {
-				// This really shouldn't be occuring
-				// ---------------------------------------------------
-				sess.Destroy(true)
-				SetCookieDestroy(g, sh)
-				SetCookieDestroy(g, sh+"_xo")
-				j.Detail = "Session destroyed; We have a user but failed to create a session!"
-				j.Status = false
-			}
+	} else if !form.hasPass() || !u.ValidatePassword(form.Pass) {
 
+		// fmt.Println("  ==> PW:FAIL")
+		s.LoginFailed(cli, &u)
+		j.Detail = s.loginFailure("Password did not match.")
+		j.Status = false
+
+	} else {
+
+		if !u.TOTPEnabled {
+			s.LoginSucceeded(&u)
+			s.startSession(g, &u, form.hasKeep(), &j)
+		} else if err := s.beginChallenge(g, &u, form.hasKeep()); err == nil {
+			j.Detail = "MFA code required."
+			j.Data = map[string]interface{}{"mfa": true}
 		}
 	}
+	s.flashDetail(g, &j)
 	g.JSON(http.StatusOK, j)
 }
 
+// loginFailure returns detail or, if `Service.GenericLoginFailure`
+// is set, a message that does not tell if the user exists.
+func (s *Service) loginFailure(detail string) string {
+	if s.GenericLoginFailure {
+		return defaultLoginFailure
+	}
+	return detail
+}
+
+// serveLoginMFA completes a login that is pending a TOTP code
+// (`FormSession.Code`).
+func (s *Service) serveLoginMFA(g *gin.Context) {
+
+	form := GetFormSession(g.Request)
+
+	j := LogonModel{Action: actionLogin, Detail: "No pending login.", Status: false}
+
+	cli := getClientString(g)
+	if c, u, ok := s.pendingChallenge(g); !ok {
+		SetCookieDestroy(g, s.SessHost()+"_mfa")
+	} else if until, locked := s.LoginLocked(cli, &u); locked {
+		lockedOut(&j, until)
+	} else if !u.TOTPValidate(form.Code) {
+		s.failChallenge(cli, &c, &u)
+		j.Detail = "MFA code did not match."
+	} else if s.endChallenge(g, &c) {
+		s.LoginSucceeded(&u)
+		s.startSession(g, &u, c.KeepAlive, &j)
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// serveLoginRecovery completes a login that is pending a TOTP code
+// given one of the user's recovery codes (`FormSession.Code`) instead.
+func (s *Service) serveLoginRecovery(g *gin.Context) {
+
+	form := GetFormSession(g.Request)
+
+	j := LogonModel{Action: actionLogin, Detail: "No pending login.", Status: false}
+
+	cli := getClientString(g)
+	if c, u, ok := s.pendingChallenge(g); !ok {
+		SetCookieDestroy(g, s.SessHost()+"_mfa")
+	} else if until, locked := s.LoginLocked(cli, &u); locked {
+		lockedOut(&j, until)
+	} else if !u.RedeemRecoveryCode(form.Code) {
+		s.failChallenge(cli, &c, &u)
+		j.Detail = "Recovery code did not match."
+	} else if s.endChallenge(g, &c) {
+		s.LoginSucceeded(&u)
+		s.startSession(g, &u, c.KeepAlive, &j)
+		if j.Status {
+			j.Detail = fmt.Sprintf("Logged in; %d recovery code(s) left.", u.RecoveryCodesLeft())
+		}
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// startSession reuses this device's session for u or creates a new one,
+// sets the session cookies and reports the result to j.
+func (s *Service) startSession(g *gin.Context, u *User, keep bool, j *LogonModel) {
+
+	sh := s.SessHost()
+
+	if sess, success := u.UserSession(sh, g); success { // reuse this device's session
+
+		sess.Refresh(false)
+		sess.KeepAlive = keep
+		sess.Client = getClientString(g)
+		sess.Save()
+		sess.SetBrowserCookieFromSession(g, u.Name, sh)
+		j.Detail = "Logged in."
+		j.Status = true
+		data := map[string]interface{}{"user": u.Name, "created": sess.Created, "expires": sess.Expires}
+		if token := s.renewCSRF(g, &sess); token != "" {
+			data["csrf"] = token
+		}
+		j.Data = data
+
+	} else if failed, ss := u.CreateSession(g, sh, keep); !failed { // new device
+
+		ss.SetBrowserCookieFromSession(g, u.Name, sh)
+		j.Detail = "Logged in."
+		j.Status = true
+		data := map[string]interface{}{"user": u.Name, "created": ss.Created, "expires": ss.Expires}
+		if token := s.renewCSRF(g, &ss); token != "" {
+			data["csrf"] = token
+		}
+		j.Data = data
+
+	} else {
+		// This really shouldn't be occuring
+		// ---------------------------------------------------
+		SetCookieDestroy(g, sh)
+		SetCookieDestroy(g, sh+"_xo")
+		destroyClaimsCookie(g, sh)
+		j.Detail = "Session destroyed; We have a user but failed to create a session!"
+		j.Status = false
+	}
+}
+
+// serveRegister creates a user and a session for it.
+//
+// Names and passwords failing `Service.UsernamePolicy` or
+// `Service.PasswordPolicy` are answered with
+// `{status: false, data: {violations: []Violation}}`.
 func (s *Service) serveRegister(g *gin.Context) {
 
 	j := LogonModel{Action: actionRegister, Detail: "user creation failed.", Status: false}
 
 	form := GetFormSession(g.Request)
 
+	var perr *PolicyError
 	u := User{}
-	if e := u.Create(form.User, form.Pass); e != 0 {
-		switch e {
-		case -1:
-			j.Detail = "Failed to load db."
-			break
-		case 1:
+	if err := u.Register(form.User, form.Pass); err != nil {
+		switch {
+		case errors.As(err, &perr):
+			j.Detail = "Check Name and Pass."
+			j.Data = map[string]interface{}{"violations": perr.Violations}
+		case errors.Is(err, ErrUserExists):
 			j.Detail = "User record already exists."
-			break
-		case 2:
-			j.Detail = "Chek Name and Pass length; should be >= 5 chars."
-			break
+		default:
+			j.Detail = "Failed to load db."
 		}
 	} else {
 
 		sh := s.SessHost()
 		e, sess := u.CreateSession(g, sh, form.hasKeep())
 		if !e {
-			if form.hasKeep() {
-				SetCookieExpires(g, sh, sess.SessID, sess.Expires)
-			} else {
-				SetCookieSessOnly(g, sh, sess.SessID)
-			}
-			SetCookieSessOnly(g, sh+"_xo", u.Name)
+			sess.SetBrowserCookieFromSession(g, u.Name, sh)
 			j.Status = true
 			j.Detail = "User and Session created."
+			if token := s.renewCSRF(g, &sess); token != "" {
+				j.Data = map[string]interface{}{"csrf": token}
+			}
 		} else {
 			j.Status = false
 			j.Detail = "User created; session failed."
 		}
 	}
+	s.flashDetail(g, &j)
+	g.JSON(http.StatusOK, j)
+}
+
+// serveUnregister deletes the logged in user and all of the user's sessions.
+// The user's password must be supplied (as in `FormSession.Pass`) to confirm.
+func (s *Service) serveUnregister(g *gin.Context) {
+
+	j := LogonModel{Action: actionUnregister, Detail: "user deletion failed.", Status: false}
+
+	form := GetFormSession(g.Request)
+	sh := s.SessHost()
+
+	if _, u, ok := s.currentUser(g); !ok {
+		j.Detail = "Not logged in."
+	} else if !form.hasPass() {
+		j.Detail = "Password required."
+	} else if s.passwordLocked(g, &u, &j) {
+		// locked out
+	} else if err := u.Delete(form.Pass); err != nil {
+		if errors.Is(err, ErrPasswordMismatch) {
+			s.LoginFailed(getClientString(g), &u)
+			j.Detail = "Password did not match."
+		}
+	} else {
+		SetCookieDestroy(g, sh)
+		SetCookieDestroy(g, sh+"_xo")
+		destroyClaimsCookie(g, sh)
+		j.Detail = "User and sessions deleted."
+		j.Status = true
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// serveSessions lists the sessions of the logged in user as `[]SessionInfo`.
+func (s *Service) serveSessions(g *gin.Context) {
+	j := LogonModel{Action: actionSessions, Detail: "Not logged in.", Status: false}
+	if sess, u, ok := s.currentUser(g); ok {
+		if list, err := u.Sessions(); err != nil {
+			j.Detail = "Failed to load sessions."
+		} else {
+			infos := make([]SessionInfo, len(list))
+			for i, x := range list {
+				infos[i] = x.Info(x.ID == sess.ID)
+			}
+			j.Detail = "found"
+			j.Status = true
+			j.Data = infos
+		}
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// serveSessionName labels one of the logged in user's sessions.
+// `FormSession.Sess` is the session id and `FormSession.Label` its new name.
+func (s *Service) serveSessionName(g *gin.Context) {
+	j := LogonModel{Action: actionSessions, Detail: "Not logged in.", Status: false}
+	form := GetFormSession(g.Request)
+	if _, u, ok := s.currentUser(g); ok {
+		if err := u.RenameSession(form.sessID(), form.Label); err != nil {
+			j.Detail = "No session record."
+		} else {
+			j.Detail = "Session renamed."
+			j.Status = true
+		}
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// serveSessionRevoke expires one of the logged in user's sessions.
+// `FormSession.Sess` is the session id.
+func (s *Service) serveSessionRevoke(g *gin.Context) {
+	j := LogonModel{Action: actionSessions, Detail: "Not logged in.", Status: false}
+	form := GetFormSession(g.Request)
+	if sess, u, ok := s.currentUser(g); ok {
+		if err := u.RevokeSession(form.sessID()); err != nil {
+			j.Detail = "No session record."
+		} else {
+			if form.sessID() == sess.ID {
+				SetCookieDestroy(g, s.SessHost())
+				destroyClaimsCookie(g, s.SessHost())
+			}
+			j.Detail = "Session revoked."
+			j.Status = true
+		}
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// servePassword changes the logged in user's password.
+// `FormSession.Pass` is the current password and `FormSession.NewPass` the new one.
+//
+// The current session is rotated (given a new SessID) and, if
+// `Service.RevokeOnPasswordChange` is set, all other sessions are revoked.
+func (s *Service) servePassword(g *gin.Context) {
+
+	j := LogonModel{Action: actionPassword, Detail: "Not logged in.", Status: false}
+
+	form := GetFormSession(g.Request)
+	sh := s.SessHost()
+
+	var perr *PolicyError
+	if sess, u, ok := s.currentUser(g); ok && !s.passwordLocked(g, &u, &j) {
+		switch err := u.ChangePassword(form.Pass, form.NewPass); {
+		case errors.Is(err, ErrPasswordMismatch):
+			s.LoginFailed(getClientString(g), &u)
+			j.Detail = "Password did not match."
+		case errors.As(err, &perr):
+			j.Detail = "Check Pass."
+			j.Data = map[string]interface{}{"violations": perr.Violations}
+		case err != nil:
+			j.Detail = "Failed to save password."
+		default:
+			if s.RevokeOnPasswordChange {
+				u.RevokeAllSessions(&sess)
+			}
+			sess.Refresh(true)
+			sess.SetBrowserCookieFromSession(g, u.Name, sh)
+			j.Detail = "Password changed."
+			j.Status = true
+		}
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// serveReset issues a password reset for `FormSession.User` and hands
+// its token to `Service.ResetSender` in the background.
+//
+// The response does not reveal whether the user exists.  Requests are
+// limited per user name and client (see `Service.ResetUserLimit`).
+func (s *Service) serveReset(g *gin.Context) {
+	j := LogonModel{Action: actionReset, Detail: "Password reset is not available.", Status: false}
+	if s.ResetSender != nil {
+		form := GetFormSession(g.Request)
+		if !s.resetAllowed(getClientString(g), form.User) {
+			j.Detail = "Too many reset requests; try again later."
+		} else {
+			// answer before looking up the user so the time taken does not
+			// tell whether it exists.
+			go func(name string) {
+				if err := s.SendPasswordReset(name); err != nil && !errors.Is(err, ErrNotFound) {
+					fmt.Fprintf(os.Stderr, "reset: %v\n", err)
+				}
+			}(form.User)
+			j.Detail = "If the user exists, a reset token has been sent."
+			j.Status = true
+		}
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// serveResetConfirm consumes a password reset token (`FormSession.Token`)
+// and sets the user's password to `FormSession.NewPass`.
+func (s *Service) serveResetConfirm(g *gin.Context) {
+	j := LogonModel{Action: actionReset, Detail: "password reset failed.", Status: false}
+	form := GetFormSession(g.Request)
+	var perr *PolicyError
+	switch _, err := ConsumeResetToken(form.Token, form.NewPass); {
+	case errors.Is(err, ErrResetToken):
+		j.Detail = "Invalid or expired reset token."
+	case errors.As(err, &perr):
+		j.Detail = "Check Pass."
+		j.Data = map[string]interface{}{"violations": perr.Violations}
+	case err != nil:
+	default:
+		j.Detail = "Password changed."
+		j.Status = true
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// serveMFAEnroll generates a TOTP secret for the logged in user and
+// serves it with its otpauth:// URI.  TOTP is enabled once the user
+// supplies a valid code to "/mfa/confirm/".
+func (s *Service) serveMFAEnroll(g *gin.Context) {
+	j := LogonModel{Action: actionMFA, Detail: "Not logged in.", Status: false}
+	if _, u, ok := s.currentUser(g); ok {
+		issuer := s.MFAIssuer
+		if issuer == "" {
+			issuer = s.AppID
+		}
+		if secret, uri, err := u.TOTPEnroll(issuer); errors.Is(err, ErrTOTPState) {
+			j.Detail = "MFA is already enabled."
+		} else if err != nil {
+			j.Detail = "Failed to enroll."
+		} else {
+			j.Detail = "Confirm with a code from your authenticator."
+			j.Status = true
+			j.Data = map[string]interface{}{"secret": secret, "uri": uri}
+		}
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// serveMFAConfirm enables TOTP for the logged in user given a valid
+// code (`FormSession.Code`) for the secret issued by "/mfa/enroll/".
+//
+// A first batch of recovery codes is served on success.
+func (s *Service) serveMFAConfirm(g *gin.Context) {
+	j := LogonModel{Action: actionMFA, Detail: "Not logged in.", Status: false}
+	form := GetFormSession(g.Request)
+	if _, u, ok := s.currentUser(g); ok {
+		switch err := u.TOTPConfirm(form.Code); {
+		case errors.Is(err, ErrTOTPState):
+			j.Detail = "MFA is not pending confirmation."
+		case errors.Is(err, ErrTOTPCode):
+			j.Detail = "MFA code did not match."
+		case err != nil:
+			j.Detail = "Failed to enable MFA."
+		default:
+			j.Detail = "MFA enabled."
+			j.Status = true
+			if codes, err := u.GenerateRecoveryCodes(0); err == nil {
+				j.Data = map[string]interface{}{"recovery": codes}
+			}
+		}
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// serveMFADisable turns off TOTP for the logged in user given the
+// user's password (`FormSession.Pass`).
+func (s *Service) serveMFADisable(g *gin.Context) {
+	j := LogonModel{Action: actionMFA, Detail: "Not logged in.", Status: false}
+	form := GetFormSession(g.Request)
+	if _, u, ok := s.currentUser(g); ok && !s.passwordLocked(g, &u, &j) {
+		switch err := u.TOTPDisable(form.Pass); {
+		case errors.Is(err, ErrTOTPState):
+			j.Detail = "MFA is not enabled."
+		case errors.Is(err, ErrPasswordMismatch):
+			s.LoginFailed(getClientString(g), &u)
+			j.Detail = "Password did not match."
+		case err != nil:
+			j.Detail = "Failed to disable MFA."
+		default:
+			j.Detail = "MFA disabled."
+			j.Status = true
+		}
+	}
+	g.JSON(http.StatusOK, j)
+}
+
+// serveMFARecovery replaces the logged in user's recovery codes with a
+// new batch given the user's password (`FormSession.Pass`).
+func (s *Service) serveMFARecovery(g *gin.Context) {
+	j := LogonModel{Action: actionMFA, Detail: "Not logged in.", Status: false}
+	form := GetFormSession(g.Request)
+	if _, u, ok := s.currentUser(g); ok && !s.passwordLocked(g, &u, &j) {
+		if !u.TOTPEnabled {
+			j.Detail = "MFA is not enabled."
+		} else if !u.validate(form.Pass) {
+			s.LoginFailed(getClientString(g), &u)
+			j.Detail = "Password did not match."
+		} else if codes, err := u.GenerateRecoveryCodes(0); err != nil {
+			j.Detail = "Failed to generate recovery codes."
+		} else {
+			j.Detail = "Recovery codes replaced."
+			j.Status = true
+			j.Data = map[string]interface{}{"recovery": codes}
+		}
+	}
 	g.JSON(http.StatusOK, j)
 }
@@ -0,0 +1,274 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testClient serves requests to a Service backed by a `memStore`,
+// keeping cookies between requests as a browser would.  Call close
+// once done.
+type testClient struct {
+	t       *testing.T
+	engine  *gin.Engine
+	svc     *Service
+	cookies map[string]*http.Cookie
+	header  http.Header
+	prev    *Service
+}
+
+func newTestClient(t *testing.T, configure func(s *Service)) *testClient {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	OverrideCrypto(1024, 1, -1, -1) // cheap hashes
+	prev := service
+	svc := DefaultService()
+	svc.Store = newMemStore()
+	svc.URIEnforce = []string{"^/index"}
+	if configure != nil {
+		configure(svc)
+	}
+	e := gin.New()
+	SetupService(svc, e, "", "", -1, -1)
+	e.GET("/index/", func(g *gin.Context) { g.String(http.StatusOK, "hello") })
+	return &testClient{t: t, engine: e, svc: svc, cookies: map[string]*http.Cookie{}, header: http.Header{}, prev: prev}
+}
+
+// close restores the service and hash parameters of before the client.
+func (c *testClient) close() {
+	service = c.prev
+	OverrideCrypto(int64(64*1024), 2, -1, -1)
+}
+
+// browser returns a client on the same service with a cookie-jar
+// of its own, as though it were another device.
+func (c *testClient) browser() *testClient {
+	x := *c
+	x.cookies, x.header = map[string]*http.Cookie{}, http.Header{}
+	return &x
+}
+
+// do serves a request with form encoded to the query (GET) or body.
+func (c *testClient) do(method, path string, form url.Values) (int, LogonModel) {
+	c.t.Helper()
+	var req *http.Request
+	if method == http.MethodGet {
+		req = httptest.NewRequest(method, path+"?"+form.Encode(), nil)
+	} else {
+		req = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	for _, ck := range c.cookies {
+		req.AddCookie(ck)
+	}
+	w := httptest.NewRecorder()
+	c.engine.ServeHTTP(w, req)
+	for _, ck := range w.Result().Cookies() {
+		if ck.MaxAge < 0 {
+			delete(c.cookies, ck.Name)
+		} else {
+			c.cookies[ck.Name] = ck
+		}
+	}
+	j := LogonModel{}
+	json.Unmarshal(w.Body.Bytes(), &j)
+	return w.Code, j
+}
+
+func TestServeLogin(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+	steps := []struct {
+		path   string
+		form   url.Values
+		code   int
+		status bool
+	}{
+		{"/index/", nil, http.StatusUnauthorized, false},
+		{"/login/", user, http.StatusOK, false},
+		{"/register/", user, http.StatusOK, true},
+		{"/stat/", nil, http.StatusOK, true},
+		{"/index/", nil, http.StatusOK, false},
+		{"/register/", user, http.StatusOK, false},
+		{"/logout/", nil, http.StatusOK, true},
+		{"/stat/", nil, http.StatusOK, false},
+		{"/index/", nil, http.StatusUnauthorized, false},
+		{"/login/", url.Values{"user": {"admin1"}, "pass": {"password2"}}, http.StatusOK, false},
+		{"/login/", user, http.StatusOK, true},
+		{"/stat/", nil, http.StatusOK, true},
+	}
+	for i, step := range steps {
+		code, j := c.do(http.MethodGet, step.path, step.form)
+		if code != step.code || j.Status != step.status {
+			t.Fatalf("step %d %s: got %d %+v, want %d status %v", i, step.path, code, j, step.code, step.status)
+		}
+	}
+	m := c.svc.Store.(*memStore)
+	if n := len(m.users); n != 1 {
+		t.Errorf("stored %d users, want 1", n)
+	}
+	sessid, _ := url.QueryUnescape(c.cookies[c.svc.SessHost()].Value)
+	if _, err := m.sessionWhere(func(x Session) bool { return x.SessHash == hashToken(sessid) }); err != nil {
+		t.Errorf("no session stored by the hash of its ID: %v", err)
+	}
+}
+
+func TestServeLoginGeneric(t *testing.T) {
+	for _, generic := range []bool{false, true} {
+		c := newTestClient(t, func(s *Service) { s.GenericLoginFailure = generic })
+		c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}})
+		c.do(http.MethodGet, "/logout/", nil)
+		_, unknown := c.do(http.MethodGet, "/login/", url.Values{"user": {"nobody1"}, "pass": {"password1"}})
+		_, wrong := c.do(http.MethodGet, "/login/", url.Values{"user": {"admin1"}, "pass": {"password2"}})
+		if unknown.Status || wrong.Status {
+			t.Fatalf("generic %v: login succeeded: %+v, %+v", generic, unknown, wrong)
+		}
+		if (unknown.Detail == wrong.Detail) != generic {
+			t.Errorf("generic %v: details %q and %q", generic, unknown.Detail, wrong.Detail)
+		}
+		c.close()
+	}
+}
+
+func TestServeUnregister(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}})
+	for _, pass := range []string{"", "password2"} {
+		if _, j := c.do(http.MethodGet, "/unregister/", url.Values{"pass": {pass}}); j.Status {
+			t.Fatalf("unregister with pass %q: %+v", pass, j)
+		}
+	}
+	if _, j := c.do(http.MethodGet, "/unregister/", url.Values{"pass": {"password1"}}); !j.Status {
+		t.Fatalf("unregister: %+v", j)
+	}
+	m := c.svc.Store.(*memStore)
+	if len(m.users) != 0 || len(m.sessions) != 0 {
+		t.Errorf("%d users and %d sessions remain", len(m.users), len(m.sessions))
+	}
+	if _, j := c.do(http.MethodGet, "/stat/", nil); j.Status {
+		t.Errorf("stat after unregister: %+v", j)
+	}
+}
+
+func TestServeSessions(t *testing.T) {
+	a := newTestClient(t, nil)
+	defer a.close()
+	user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+	a.header.Set("User-Agent", "agent-a")
+	a.do(http.MethodGet, "/register/", user)
+	b := a.browser()
+	b.header.Set("User-Agent", "agent-b")
+	if _, j := b.do(http.MethodGet, "/login/", user); !j.Status {
+		t.Fatalf("login on b: %+v", j)
+	}
+
+	_, j := a.do(http.MethodGet, "/sessions/", nil)
+	var infos []SessionInfo
+	data, _ := json.Marshal(j.Data)
+	json.Unmarshal(data, &infos)
+	if len(infos) != 2 {
+		t.Fatalf("sessions: %+v", j)
+	}
+	var other SessionInfo
+	for _, x := range infos {
+		if x.Current != (x.Agent == "agent-a") {
+			t.Errorf("session %+v marked current = %v", x, x.Current)
+		}
+		if !x.Current {
+			other = x
+		}
+	}
+	id := fmt.Sprint(other.ID)
+	if _, j := a.do(http.MethodGet, "/sessions/name/", url.Values{"sess": {id}, "label": {"phone"}}); !j.Status {
+		t.Errorf("name: %+v", j)
+	}
+	if x := a.svc.Store.(*memStore).sessions[other.ID]; x.Name != "phone" {
+		t.Errorf("session named %q", x.Name)
+	}
+	if _, j := a.do(http.MethodGet, "/sessions/revoke/", url.Values{"sess": {id}}); !j.Status {
+		t.Errorf("revoke: %+v", j)
+	}
+	if _, j := b.do(http.MethodGet, "/stat/", nil); j.Status {
+		t.Errorf("revoked session still valid: %+v", j)
+	}
+	if _, j := a.do(http.MethodGet, "/stat/", nil); !j.Status {
+		t.Errorf("current session revoked: %+v", j)
+	}
+	if _, j := a.do(http.MethodGet, "/sessions/revoke/", url.Values{"sess": {"999"}}); j.Status {
+		t.Errorf("revoke of unknown session: %+v", j)
+	}
+}
+
+func TestServeLogoutAll(t *testing.T) {
+	a := newTestClient(t, nil)
+	defer a.close()
+	user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+	a.do(http.MethodGet, "/register/", user)
+	b, c := a.browser(), a.browser()
+	b.header.Set("User-Agent", "agent-b")
+	c.header.Set("User-Agent", "agent-c")
+	b.do(http.MethodGet, "/login/", user)
+	c.do(http.MethodGet, "/login/", user)
+
+	_, j := a.do(http.MethodGet, "/logout/all/", nil)
+	if n, _ := j.Data.(map[string]interface{})["revoked"].(float64); !j.Status || n != 2 {
+		t.Fatalf("logout/all: %+v", j)
+	}
+	for name, x := range map[string]*testClient{"a": a, "b": b, "c": c} {
+		if _, j := x.do(http.MethodGet, "/stat/", nil); j.Status != (name == "a") {
+			t.Errorf("%s: stat %+v", name, j)
+		}
+	}
+}
+
+func TestServePassword(t *testing.T) {
+	for _, revoke := range []bool{false, true} {
+		a := newTestClient(t, func(s *Service) { s.RevokeOnPasswordChange = revoke })
+		user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+		a.do(http.MethodGet, "/register/", user)
+		b := a.browser()
+		b.do(http.MethodGet, "/login/", user)
+
+		for _, form := range []url.Values{
+			{"pass": {"password2"}, "newpass": {"password3"}},
+			{"pass": {"password1"}, "newpass": {"abc"}},
+		} {
+			if _, j := a.do(http.MethodGet, "/password/", form); j.Status {
+				t.Errorf("revoke %v: %v: %+v", revoke, form, j)
+			}
+		}
+		sid := a.cookies[a.svc.SessHost()].Value
+		if _, j := a.do(http.MethodGet, "/password/", url.Values{"pass": {"password1"}, "newpass": {"password3"}}); !j.Status {
+			t.Fatalf("revoke %v: password: %+v", revoke, j)
+		}
+		if a.cookies[a.svc.SessHost()].Value == sid {
+			t.Errorf("revoke %v: session not rotated", revoke)
+		}
+		if _, j := a.do(http.MethodGet, "/stat/", nil); !j.Status {
+			t.Errorf("revoke %v: current session lost: %+v", revoke, j)
+		}
+		if _, j := b.do(http.MethodGet, "/stat/", nil); j.Status == revoke {
+			t.Errorf("revoke %v: other session: %+v", revoke, j)
+		}
+		c := a.browser()
+		if _, j := c.do(http.MethodGet, "/login/", user); j.Status {
+			t.Errorf("revoke %v: old password accepted", revoke)
+		}
+		if _, j := c.do(http.MethodGet, "/login/", url.Values{"user": {"admin1"}, "pass": {"password3"}}); !j.Status {
+			t.Errorf("revoke %v: new password: %+v", revoke, j)
+		}
+		a.close()
+	}
+}
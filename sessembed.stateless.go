@@ -0,0 +1,221 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionClaims are the claims of a session carried (encrypted) by the
+// `<host>_st` cookie in stateless mode; see `Service.Stateless`.
+type SessionClaims struct {
+	ID        int64     `json:"sid"` // [sessions].[id]
+	UserID    int64     `json:"uid"` // [users].[id]
+	Client    string    `json:"cli"` // [sessions].[cli-key]
+	KeepAlive bool      `json:"keep"`
+	Expires   time.Time `json:"exp"` // the claims'; see `Service.StatelessTTL`
+}
+
+const (
+	keySessionClaims    = "session-claims"
+	defaultStatelessTTL = 5 * time.Minute
+)
+
+var (
+	// errNoStatelessKeys is returned when stateless mode has no usable key.
+	errNoStatelessKeys = errors.New("session: no StatelessKeys configured")
+	errClaimsFormat    = errors.New("session: malformed session claims")
+)
+
+// IsValid returns if the claims have not expired.
+func (c *SessionClaims) IsValid() bool {
+	return c.ID != 0 && time.Now().Before(c.Expires)
+}
+
+// Claims returns the session claims of the requesting client, as
+// validated by our middleware or read from its `<host>_st` cookie.
+//
+// Only available in stateless mode; the claims are not checked against
+// the database (see `Service.URISensitive`).
+func (s *Service) Claims(g *gin.Context) (SessionClaims, bool) {
+	if x, ok := g.Get(keySessionClaims); ok {
+		c, ok := x.(SessionClaims)
+		return c, ok
+	}
+	return s.readClaims(g)
+}
+
+// readClaims decrypts the `<host>_st` cookie and checks that its claims
+// have not expired and are bound to the requesting client.
+func (s *Service) readClaims(g *gin.Context) (SessionClaims, bool) {
+	c := SessionClaims{}
+	if !s.Stateless {
+		return c, false
+	}
+	name := s.SessHost() + "_st"
+	value := getCookieValue(name, g)
+	if value == "" {
+		return c, false
+	}
+	if err := decryptClaims(s.StatelessKeys, name, value, &c); err != nil {
+		return SessionClaims{}, false
+	}
+	if !c.IsValid() || c.Client != getClientString(g) {
+		return SessionClaims{}, false
+	}
+	return c, true
+}
+
+// statelessValid validates the requesting client's session claims in
+// memory; on `Service.URISensitive` URIs the session is also looked up
+// so that revoked (expired) sessions are refused.
+//
+// Missing or expired claims are renewed from a valid session cookie.
+func (s *Service) statelessValid(g *gin.Context) bool {
+	c, ok := s.readClaims(g)
+	if !ok {
+		return s.renewClaims(g)
+	}
+	if sensitive, _ := s.isunsafe(g.Request.RequestURI, s.URISensitive...); sensitive {
+		sess, err := storage().SessionByID(c.ID)
+		if err != nil || sess.UserID != c.UserID || !sess.IsValid() {
+			return false
+		}
+	}
+	g.Set(keySessionClaims, c)
+	return true
+}
+
+// renewClaims looks up the requesting client's session and, if valid,
+// serves it new claims.
+func (s *Service) renewClaims(g *gin.Context) bool {
+	sh := s.SessHost()
+	sess, ok := QueryCookie(sh, g)
+	if !ok || !sess.IsValid() {
+		return false
+	}
+	setClaimsCookie(g, sh, &sess)
+	g.Set(keySessionClaims, newClaims(&sess))
+	return true
+}
+
+// newClaims returns the claims of sess; they expire after
+// `Service.StatelessTTL` (or with sess, if sooner).
+func newClaims(sess *Session) SessionClaims {
+	ttl := defaultStatelessTTL
+	if service != nil && service.StatelessTTL > 0 {
+		ttl = service.StatelessTTL
+	}
+	expires := time.Now().Add(ttl)
+	if sess.Expires.Before(expires) {
+		expires = sess.Expires
+	}
+	return SessionClaims{
+		ID:        sess.ID,
+		UserID:    sess.UserID,
+		Client:    sess.Client,
+		KeepAlive: sess.KeepAlive,
+		Expires:   expires,
+	}
+}
+
+// setClaimsCookie stores the claims of sess to the `<sh>_st` cookie
+// when our service is in stateless mode.
+func setClaimsCookie(g *gin.Context, sh string, sess *Session) {
+	if service == nil || !service.Stateless {
+		return
+	}
+	name := sh + "_st"
+	value, err := encryptClaims(service.StatelessKeys, name, newClaims(sess))
+	if err != nil {
+		fmt.Printf("ERROR(stateless): %s\n", err.Error())
+		return
+	}
+	if sess.KeepAlive {
+		SetCookieExpires(g, name, value, sess.Expires)
+	} else {
+		SetCookieSessOnly(g, name, value)
+	}
+}
+
+// destroyClaimsCookie removes the `<sh>_st` cookie in stateless mode.
+func destroyClaimsCookie(g *gin.Context, sh string) {
+	if service != nil && service.Stateless {
+		SetCookieDestroy(g, sh+"_st")
+	}
+}
+
+// encryptClaims seals c with AES-GCM using the first of keys; name
+// (the cookie name) is authenticated along with it.
+func encryptClaims(keys [][]byte, name string, c SessionClaims) (string, error) {
+	if len(keys) == 0 {
+		return "", errNoStatelessKeys
+	}
+	aead, err := newGCM(keys[0])
+	if err != nil {
+		return "", err
+	}
+	plain, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	nonce := NewSaltCSRNG(aead.NonceSize())
+	sealed := aead.Seal(nonce, nonce, plain, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decryptClaims opens value with any of keys into c.
+func decryptClaims(keys [][]byte, name, value string, c *SessionClaims) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	err = errNoStatelessKeys
+	for _, key := range keys {
+		aead, e := newGCM(key)
+		if e != nil {
+			err = e
+			continue
+		}
+		if len(sealed) < aead.NonceSize() {
+			return errClaimsFormat
+		}
+		nonce, box := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plain, e := aead.Open(nil, nonce, box, []byte(name))
+		if e != nil {
+			err = e
+			continue
+		}
+		return json.Unmarshal(plain, c)
+	}
+	return err
+}
+
+// checkStatelessKeys returns an error unless there is at least one key
+// and every key is an AES key.
+func checkStatelessKeys(keys [][]byte) error {
+	if len(keys) == 0 {
+		return errNoStatelessKeys
+	}
+	for i, key := range keys {
+		if _, err := newGCM(key); err != nil {
+			return fmt.Errorf("session: StatelessKeys[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// newGCM returns AES-GCM for a 16, 24 or 32 byte key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
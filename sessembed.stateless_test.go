@@ -0,0 +1,157 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// flipLastBit returns sealed with a bit of its ciphertext (the GCM tag)
+// flipped.
+func flipLastBit(t *testing.T, sealed string) string {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 1
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestClaimsEncryption(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 16)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	c := SessionClaims{ID: 7, UserID: 3, Client: "cli", KeepAlive: true, Expires: time.Now().Add(time.Minute).Round(0)}
+	sealed, err := encryptClaims([][]byte{oldKey}, "app_st", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		keys  [][]byte
+		cname string
+		value string
+		ok    bool
+	}{
+		{"same key", [][]byte{oldKey}, "app_st", sealed, true},
+		{"rotated", [][]byte{newKey, oldKey}, "app_st", sealed, true},
+		{"wrong key", [][]byte{newKey}, "app_st", sealed, false},
+		{"other cookie", [][]byte{oldKey}, "other_st", sealed, false},
+		{"tampered", [][]byte{oldKey}, "app_st", flipLastBit(t, sealed), false},
+		{"truncated", [][]byte{oldKey}, "app_st", sealed[:8], false},
+		{"no keys", nil, "app_st", sealed, false},
+	}
+	for _, tt := range tests {
+		got := SessionClaims{}
+		err := decryptClaims(tt.keys, tt.cname, tt.value, &got)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: decryptClaims error = %v", tt.name, err)
+			continue
+		}
+		if tt.ok && (got.ID != c.ID || got.UserID != c.UserID || got.Client != c.Client || !got.Expires.Equal(c.Expires)) {
+			t.Errorf("%s: decryptClaims = %+v, want %+v", tt.name, got, c)
+		}
+	}
+	if _, err := encryptClaims(nil, "app_st", c); err == nil {
+		t.Error("encryptClaims without keys")
+	}
+}
+
+func TestServeStateless(t *testing.T) {
+	c := newTestClient(t, func(s *Service) {
+		s.Stateless = true
+		s.StatelessKeys = [][]byte{bytes.Repeat([]byte("k"), 32)}
+		s.URIEnforce = []string{"^/index", "^/secure"}
+		s.URISensitive = []string{"^/secure"}
+	})
+	defer c.close()
+	c.engine.GET("/secure/", func(g *gin.Context) { g.String(http.StatusOK, "hello") })
+	get := func(path string) int {
+		code, _ := c.do(http.MethodGet, path, nil)
+		return code
+	}
+	c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}})
+	if get("/index/") != http.StatusOK || get("/secure/") != http.StatusOK {
+		t.Fatal("session not valid after register")
+	}
+	stolen := map[string]*http.Cookie{}
+	for k, v := range c.cookies {
+		stolen[k] = v
+	}
+	c.do(http.MethodGet, "/logout/", nil)
+	if code := get("/index/"); code != http.StatusUnauthorized {
+		t.Errorf("index after logout: %d", code)
+	}
+
+	// claims are not looked up but on URISensitive URIs.
+	c.cookies = stolen
+	if code := get("/index/"); code != http.StatusOK {
+		t.Errorf("index with logged out claims: %d", code)
+	}
+	if code := get("/secure/"); code != http.StatusUnauthorized {
+		t.Errorf("secure with logged out claims: %d", code)
+	}
+	st := c.cookies[c.svc.SessHost()+"_st"]
+	st.Value = flipLastBit(t, st.Value)
+	if code := get("/index/"); code != http.StatusUnauthorized {
+		t.Errorf("index with tampered claims: %d", code)
+	}
+}
+
+func TestServeStatelessTTL(t *testing.T) {
+	c := newTestClient(t, func(s *Service) {
+		s.Stateless = true
+		s.StatelessKeys = [][]byte{bytes.Repeat([]byte("k"), 32)}
+		s.StatelessTTL = 50 * time.Millisecond
+	})
+	defer c.close()
+	get := func() int {
+		code, _ := c.do(http.MethodGet, "/index/", nil)
+		return code
+	}
+	c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}})
+	st := c.svc.SessHost() + "_st"
+	claims := c.cookies[st].Value
+	time.Sleep(60 * time.Millisecond)
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("index with expired claims of a valid session: %d", code)
+	}
+	if c.cookies[st].Value == claims {
+		t.Error("claims not renewed")
+	}
+
+	stolen := map[string]*http.Cookie{}
+	for k, v := range c.cookies {
+		stolen[k] = v
+	}
+	c.do(http.MethodGet, "/logout/", nil)
+	c.cookies = stolen
+	time.Sleep(60 * time.Millisecond)
+	if code := get(); code != http.StatusUnauthorized {
+		t.Errorf("index with expired claims of a logged out session: %d", code)
+	}
+}
+
+func TestCheckStatelessKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		keys [][]byte
+		ok   bool
+	}{
+		{"none", nil, false},
+		{"aes-128", [][]byte{make([]byte, 16)}, true},
+		{"aes-256 and aes-192", [][]byte{make([]byte, 32), make([]byte, 24)}, true},
+		{"short", [][]byte{make([]byte, 10)}, false},
+		{"one bad", [][]byte{make([]byte, 32), make([]byte, 33)}, false},
+	}
+	for _, tt := range tests {
+		if err := checkStatelessKeys(tt.keys); (err == nil) != tt.ok {
+			t.Errorf("%s: checkStatelessKeys = %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionData is a bag of values kept with a session.
+//
+// Values are JSON encoded; a value is read back into any type it
+// can be decoded to.  Changes are saved to the session when the
+// request completes (see `sessMiddleware`).
+//
+// Only the keys changed are saved, merged with the session's data as
+// stored by then (see `SessionStore.SessionUpdateData`), so concurrent
+// requests on one session keep each other's changes; if two change the
+// same key, the last to complete wins.
+type SessionData struct {
+	sessionID int64
+	values    map[string]json.RawMessage
+	changed   map[string]bool // keys set or deleted
+}
+
+func (d *SessionData) change(key string) {
+	if d.changed == nil {
+		d.changed = map[string]bool{}
+	}
+	d.changed[key] = true
+}
+
+const keySessionData = "session-data"
+
+// Get decodes the value of key into v and returns true on success.
+func (d *SessionData) Get(key string, v interface{}) bool {
+	raw, ok := d.values[key]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(raw, v) == nil
+}
+
+// GetString returns the value of key if it is a string, or an empty string.
+func (d *SessionData) GetString(key string) string {
+	var v string
+	d.Get(key, &v)
+	return v
+}
+
+// Has reports wether key is set.
+func (d *SessionData) Has(key string) bool {
+	_, ok := d.values[key]
+	return ok
+}
+
+// Set stores v (which must encode to JSON) to key.
+func (d *SessionData) Set(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if d.values == nil {
+		d.values = map[string]json.RawMessage{}
+	}
+	d.values[key] = raw
+	d.change(key)
+	return nil
+}
+
+// Delete removes key.
+func (d *SessionData) Delete(key string) {
+	if _, ok := d.values[key]; ok {
+		delete(d.values, key)
+		d.change(key)
+	}
+}
+
+// Keys returns the (sorted) keys that are set.
+func (d *SessionData) Keys() []string {
+	keys := make([]string, 0, len(d.values))
+	for k := range d.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetSessionData returns the data of the requesting client's session,
+// loading it on first use within the request.
+//
+// Returns false if the client has no valid session.
+func GetSessionData(g *gin.Context) (*SessionData, bool) {
+	if x, ok := g.Get(keySessionData); ok {
+		d, ok := x.(*SessionData)
+		return d, ok
+	}
+	if service == nil {
+		return nil, false
+	}
+	var (
+		sess Session
+		ok   bool
+	)
+	if c, valid := service.Claims(g); valid {
+		var err error
+		sess, err = storage().SessionByID(c.ID)
+		ok = err == nil && sess.UserID == c.UserID
+	} else {
+		sess, ok = QueryCookie(service.SessHost(), g)
+	}
+	if !ok || !sess.IsValid() {
+		return nil, false
+	}
+	d := &SessionData{sessionID: sess.ID, values: map[string]json.RawMessage{}}
+	if sess.Data != "" {
+		if err := json.Unmarshal([]byte(sess.Data), &d.values); err != nil {
+			fmt.Printf("ERROR(session-data): %s\n", err.Error())
+		}
+	}
+	g.Set(keySessionData, d)
+	return d, true
+}
+
+// SessionGet decodes the value of key from the requesting client's
+// session into v; see `SessionData.Get`.
+func SessionGet(g *gin.Context, key string, v interface{}) bool {
+	if d, ok := GetSessionData(g); ok {
+		return d.Get(key, v)
+	}
+	return false
+}
+
+// SessionSet stores v to key of the requesting client's session;
+// returns ErrNotFound if the client has no valid session.
+//
+// Concurrent requests setting the same key: the last to complete wins.
+func SessionSet(g *gin.Context, key string, v interface{}) error {
+	d, ok := GetSessionData(g)
+	if !ok {
+		return ErrNotFound
+	}
+	return d.Set(key, v)
+}
+
+// SessionDelete removes key from the requesting client's session.
+func SessionDelete(g *gin.Context, key string) {
+	if d, ok := GetSessionData(g); ok {
+		d.Delete(key)
+	}
+}
+
+// saveSessionData saves the keys of the requesting client's session
+// data that were changed during the request, merged with the data
+// stored since it was loaded.
+func saveSessionData(g *gin.Context) {
+	x, ok := g.Get(keySessionData)
+	if !ok {
+		return
+	}
+	d, ok := x.(*SessionData)
+	if !ok || len(d.changed) == 0 {
+		return
+	}
+	if err := storage().SessionUpdateData(d.sessionID, func(data string) (string, error) {
+		return mergeSessionData(data, d.values, d.changed)
+	}); err != nil {
+		fmt.Printf("ERROR(session-data): %s\n", err.Error())
+		return
+	}
+	d.changed = nil
+}
+
+// mergeSessionData returns the stored data with the changed keys set
+// from values (or deleted if not in values).
+func mergeSessionData(data string, values map[string]json.RawMessage, changed map[string]bool) (string, error) {
+	merged := map[string]json.RawMessage{}
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &merged); err != nil {
+			fmt.Printf("ERROR(session-data): %s\n", err.Error())
+		}
+	}
+	for key := range changed {
+		if raw, ok := values[key]; ok {
+			merged[key] = raw
+		} else {
+			delete(merged, key)
+		}
+	}
+	if len(merged) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(merged)
+	return string(b), err
+}
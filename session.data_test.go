@@ -0,0 +1,137 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSessionData(t *testing.T) {
+	d := &SessionData{}
+	if d.Has("a") || d.Get("a", new(int)) {
+		t.Error("empty data has a")
+	}
+	d.Set("a", 1)
+	d.Set("b", "two")
+	var a int
+	if !d.Get("a", &a) || a != 1 {
+		t.Errorf("Get(a) = %d", a)
+	}
+	if d.Get("b", &a) {
+		t.Error("Get decoded a string to an int")
+	}
+	if got := d.GetString("b"); got != "two" {
+		t.Errorf("GetString(b) = %q", got)
+	}
+	if err := d.Set("c", func() {}); err == nil {
+		t.Error("Set of a func")
+	}
+	d.Delete("a")
+	if got := d.Keys(); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("Keys = %v", got)
+	}
+}
+
+func TestServeSessionData(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	c.engine.GET("/index/set/", func(g *gin.Context) {
+		if err := SessionSet(g, "n", g.Query("n")); err != nil {
+			g.String(http.StatusInternalServerError, err.Error())
+		}
+	})
+	c.engine.GET("/index/get/", func(g *gin.Context) {
+		var n string
+		SessionGet(g, "n", &n)
+		g.JSON(http.StatusOK, LogonModel{Detail: n, Status: n != ""})
+	})
+	if code, _ := c.do(http.MethodGet, "/index/set/", url.Values{"n": {"1"}}); code != http.StatusUnauthorized {
+		t.Errorf("set without a session: %d", code)
+	}
+	c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}, "keep": {"1"}})
+	if code, _ := c.do(http.MethodGet, "/index/set/", url.Values{"n": {"1"}}); code != http.StatusOK {
+		t.Fatalf("set: %d", code)
+	}
+	c.do(http.MethodGet, "/stat/", nil) // refreshes (saves) the session
+	if _, j := c.do(http.MethodGet, "/index/get/", nil); j.Detail != "1" {
+		t.Errorf("get: %+v", j)
+	}
+	if _, j := c.browser().do(http.MethodGet, "/index/get/", nil); j.Status {
+		t.Errorf("get on another client: %+v", j)
+	}
+}
+
+func TestSaveSessionDataMerges(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	m := c.svc.Store.(*memStore)
+	sess := Session{}
+	m.SessionCreate(&sess)
+	m.SessionUpdateData(sess.ID, func(string) (string, error) { return `{"a":1,"b":2,"_csrf":"token"}`, nil })
+
+	// two requests load the same data before either saves.
+	load := func() (*gin.Context, *SessionData) {
+		g, _ := gin.CreateTestContext(httptest.NewRecorder())
+		d := &SessionData{sessionID: sess.ID}
+		json.Unmarshal([]byte(`{"a":1,"b":2,"_csrf":"token"}`), &d.values)
+		g.Set(keySessionData, d)
+		return g, d
+	}
+	g1, d1 := load()
+	g2, d2 := load()
+	d1.Set("a", 10)
+	d2.Set("c", 3)
+	d2.Delete("b")
+	saveSessionData(g1)
+	saveSessionData(g2)
+
+	got, _ := m.SessionByID(sess.ID)
+	values := map[string]interface{}{}
+	json.Unmarshal([]byte(got.Data), &values)
+	want := map[string]interface{}{"a": 10.0, "c": 3.0, "_csrf": "token"}
+	if len(values) != len(want) {
+		t.Fatalf("data = %s, want %v", got.Data, want)
+	}
+	for k, v := range want {
+		if values[k] != v {
+			t.Errorf("data[%s] = %v, want %v", k, values[k], v)
+		}
+	}
+}
+
+func TestSaveSessionDataConcurrent(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	m := c.svc.Store.(*memStore)
+	sess := Session{}
+	m.SessionCreate(&sess)
+
+	// requests on one session each set a key of their own.
+	const n = 16
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g, _ := gin.CreateTestContext(httptest.NewRecorder())
+			d := &SessionData{sessionID: sess.ID}
+			d.Set(fmt.Sprint("k", i), i)
+			g.Set(keySessionData, d)
+			saveSessionData(g)
+		}(i)
+	}
+	wg.Wait()
+	got, _ := m.SessionByID(sess.ID)
+	values := map[string]int{}
+	json.Unmarshal([]byte(got.Data), &values)
+	if len(values) != n {
+		t.Errorf("data = %s, want %d keys", got.Data, n)
+	}
+}
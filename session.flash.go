@@ -0,0 +1,73 @@
+package session
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keyFlashes is the `SessionData` key flash messages are kept under.
+const keyFlashes = "_flashes"
+
+// AddFlash adds a message to be read (once) by `Flashes` on a later
+// request such as the one following a redirect.
+//
+// The message is kept with the requesting client's session data or,
+// if there is no valid session (e.g. a failed login), to the
+// `<host>_flash` cookie; call it before writing the response.
+func AddFlash(g *gin.Context, message string) {
+	if d, ok := GetSessionData(g); ok {
+		var flashes []string
+		d.Get(keyFlashes, &flashes)
+		d.Set(keyFlashes, append(flashes, message))
+		return
+	}
+	flashes := flashCookie(g)
+	if b, err := json.Marshal(append(flashes, message)); err == nil {
+		SetCookieSessOnly(g, flashCookieName(), string(b))
+		g.Set(keyFlashes, append(flashes, message))
+	}
+}
+
+// Flashes returns and clears the flash messages of the requesting
+// client; call it before writing the response.
+func Flashes(g *gin.Context) []string {
+	flashes := flashCookie(g)
+	if len(flashes) > 0 {
+		SetCookieDestroy(g, flashCookieName())
+		g.Set(keyFlashes, []string(nil))
+	}
+	if d, ok := GetSessionData(g); ok && d.Has(keyFlashes) {
+		var more []string
+		d.Get(keyFlashes, &more)
+		d.Delete(keyFlashes)
+		flashes = append(flashes, more...)
+	}
+	return flashes
+}
+
+func flashCookieName() string {
+	return service.SessHost() + "_flash"
+}
+
+// flashCookie reads the flash messages of the `<host>_flash` cookie
+// (or those set to it earlier within this request).
+func flashCookie(g *gin.Context) []string {
+	var flashes []string
+	if x, ok := g.Get(keyFlashes); ok {
+		flashes, _ = x.([]string)
+		return flashes
+	}
+	if value := getCookieValue(flashCookieName(), g); value != "" {
+		json.Unmarshal([]byte(value), &flashes)
+	}
+	return flashes
+}
+
+// flashDetail adds the detail of j as a flash message
+// if `Service.FlashDetail` is set.
+func (s *Service) flashDetail(g *gin.Context, j *LogonModel) {
+	if s.FlashDetail {
+		AddFlash(g, j.Detail)
+	}
+}
@@ -0,0 +1,51 @@
+package session
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServeFlashes(t *testing.T) {
+	c := newTestClient(t, func(s *Service) { s.FlashDetail = true })
+	defer c.close()
+	c.engine.GET("/flash/", func(g *gin.Context) {
+		if msg := g.Query("add"); msg != "" {
+			AddFlash(g, msg)
+			AddFlash(g, msg+"2")
+			return
+		}
+		g.JSON(http.StatusOK, LogonModel{Data: Flashes(g)})
+	})
+	flashes := func(add string) []interface{} {
+		_, j := c.do(http.MethodGet, "/flash/", url.Values{"add": {add}})
+		x, _ := j.Data.([]interface{})
+		return x
+	}
+	user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+
+	// without a session, flashes are kept to a cookie.
+	_, j := c.do(http.MethodGet, "/login/", user)
+	if got := flashes(""); !reflect.DeepEqual(got, []interface{}{j.Detail}) {
+		t.Errorf("flashes after failed login = %v, want %q", got, j.Detail)
+	}
+	if got := flashes(""); len(got) != 0 {
+		t.Errorf("flashes read twice: %v", got)
+	}
+
+	c.do(http.MethodGet, "/register/", user)
+	flashes("") // the detail of "/register/"
+	flashes("hello")
+	if _, ok := c.cookies[c.svc.SessHost()+"_flash"]; ok {
+		t.Error("flashes of a session kept to a cookie")
+	}
+	if got := flashes(""); !reflect.DeepEqual(got, []interface{}{"hello", "hello2"}) {
+		t.Errorf("flashes of the session = %v", got)
+	}
+	if got := flashes(""); len(got) != 0 {
+		t.Errorf("session flashes read twice: %v", got)
+	}
+}
@@ -12,13 +12,30 @@ import (
 // Session represents users who are logged in.
 type Session struct {
 	ID        int64     `gorm:"auto_increment;unique_index;primary_key;column:id"`
-	UserID    int64     `gorm:"column:user_id"` // [users].[id]
-	SessID    string    `gorm:"not null;column:sessid"`
-	Host      string    `gorm:"column:host"` // running multiple server instance/port(s)?
+	UserID    int64     `gorm:"column:user_id"`         // [users].[id]
+	SessID    string    `gorm:"-"`                      // only known when issued or read from a cookie
+	SessHash  string    `gorm:"not null;column:sessid"` // SHA-256 of SessID
+	Host      string    `gorm:"column:host"`            // running multiple server instance/port(s)?
 	Created   time.Time `gorm:"not null;column:created"`
 	Expires   time.Time `gorm:"not null;column:expires"`
 	Client    string    `gorm:"not null;column:cli-key"` // .Request.RemoteAddr
 	KeepAlive bool      `gorm:"column:keep-alive"`
+	Device    string    `gorm:"size:128;column:device"` // device-id cookie (or cli-key)
+	Agent     string    `gorm:"size:255;column:agent"`  // User-Agent
+	Name      string    `gorm:"size:64;column:name"`    // label supplied by the user
+	Data      string    `gorm:"type:text;column:data"`  // JSON; see SessionData
+}
+
+// SessionInfo is a public view of a `Session` which is safe to
+// serve to the client (it excludes the SessID and SessHash).
+type SessionInfo struct {
+	ID      int64     `json:"id"`
+	Name    string    `json:"name"`
+	Agent   string    `json:"agent"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	Valid   bool      `json:"valid"`
+	Current bool      `json:"current"`
 }
 
 // TableName Set User's table name to be `users`
@@ -47,12 +64,21 @@ func (s *Session) IsValid() bool {
 func (s *Session) Refresh(save bool) {
 	s.Created = time.Now()
 	s.Expires = service.AddDate(s)
-	s.SessID = toUBase64(NewSaltString(defaultSaltSize))
+	s.newSessID()
 	if save {
 		s.Save()
 	}
 }
 
+// newSessID issues a new `SessID`.
+//
+// Only its hash, `SessHash`, is stored to the database so that
+// a leak of the sessions table does not leak usable sessions.
+func (s *Session) newSessID() {
+	s.SessID = toUBase64(NewSaltString(defaultSaltSize))
+	s.SessHash = hashToken(s.SessID)
+}
+
 // SetBrowserCookieFromSession makes two cookies.
 //
 // The first is the sessid based on the host (port/appname) which
@@ -60,6 +86,9 @@ func (s *Session) Refresh(save bool) {
 // will be set to expire when the browser is closed and
 // the second contains the name of the user and is set to expire when browser
 // is closed.
+//
+// In stateless mode (see `Service.Stateless`) a third, `<sh>_st`, carries
+// the encrypted `SessionClaims`.
 func (s *Session) SetBrowserCookieFromSession(g *gin.Context, uname, sh string) {
 	if s.KeepAlive {
 		SetCookieExpires(g, sh, s.SessID, s.Expires)
@@ -67,6 +96,21 @@ func (s *Session) SetBrowserCookieFromSession(g *gin.Context, uname, sh string)
 		SetCookieSessOnly(g, sh, s.SessID)
 	}
 	SetCookieSessOnly(g, sh+"_xo", uname)
+	setClaimsCookie(g, sh, s)
+}
+
+// Info returns a `SessionInfo` for s.
+// current should be true if s is the session of the requesting client.
+func (s *Session) Info(current bool) SessionInfo {
+	return SessionInfo{
+		ID:      s.ID,
+		Name:    s.Name,
+		Agent:   s.Agent,
+		Created: s.Created,
+		Expires: s.Expires,
+		Valid:   s.IsValid(),
+		Current: current,
+	}
 }
 
 // GetUser gets a user by the UserID stored in the Session.
@@ -89,37 +133,24 @@ func (s *Session) Destroy(andSave bool) {
 
 // EnsureTableSessions creates table [sessions] if not exist.
 func EnsureTableSessions() {
-	var s Session
-	db, _ := iniK("error(ensure-table-sessions) loading db; (expected)\n")
-	// if !e {
-	// defer db.Close()
-	if !db.Migrator().HasTable(s) {
-		db.Migrator().CreateTable(s)
+	if err := storage().EnsureSessions(); err != nil {
+		fmt.Printf("error(ensure-table-sessions): %v\n", err)
 	}
-	// }
 }
 
 // Save session data to db.
 func (s *Session) Save() bool {
-	db, err := iniC("error(validate-session) loading database\n")
-	if err {
-		return false
-	}
-	//defer db.Close()
-	db.Save(s)
-	return db.RowsAffected > 0
+	return storage().SessionSave(s) == nil
 }
 
+// HasSessionForUser looks up the first session owned by `u` into `s`.
 func (s *Session) HasSessionForUser(u *User) (bool, error) {
-	db, err := iniC("error(validate-session) loading database\n")
-	if err {
-		fmt.Printf("session table doesn't exist?\n")
-		return false, db.Error
-	}
-	if err1 := db.Where("[user_id] = ?", u.ID).First(&s).Error; err1 != nil {
-		fmt.Printf("session find error?: %s\n", err1.Error())
-		return false, err1
+	sess, err := storage().SessionByUser(u.ID)
+	if err != nil {
+		fmt.Printf("session find error?: %s\n", err.Error())
+		return false, err
 	}
+	*s = sess
 	// fmt.Printf("we find [%v]\n", s)
 	return true, nil
 }
@@ -129,11 +160,6 @@ func (s *Session) HasSessionForUser(u *User) (bool, error) {
 // The method first fetches a list of User elements
 // then reports the Sessions with user-data (name).
 func ListSessions() ([]Session, int) {
-	sessions := []Session{}
-	db, err := iniC("error(session-cli-list) loading db\n")
-	//defer db.Close()
-	if !err {
-		db.Find(&sessions)
-	}
+	sessions, _ := storage().SessionList()
 	return sessions, len(sessions)
 }
@@ -0,0 +1,156 @@
+package session
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by a `Store` when a requested record does not exist.
+var ErrNotFound = errors.New("session: record not found")
+
+type (
+	// UserStore persists `User` records.
+	UserStore interface {
+		// EnsureUsers creates the users table if it does not exist.
+		EnsureUsers() error
+		// UserByName returns the user matching name or ErrNotFound.
+		UserByName(name string) (User, error)
+		// UserByID returns the user matching id or ErrNotFound.
+		UserByID(id int64) (User, error)
+		// UserList returns all users.
+		UserList() ([]User, error)
+		// UserCreate inserts a new user; u.ID is set on success.
+		UserCreate(u *User) error
+		// UserSave updates an existing user.
+		UserSave(u *User) error
+		// UserDelete removes the user matching id along with
+		// every session owned by the user.
+		UserDelete(id int64) error
+	}
+	// SessionStore persists `Session` records.
+	SessionStore interface {
+		// EnsureSessions creates the sessions table if it does not exist
+		// and replaces any session ID stored in clear with its hash
+		// (see `Session.SessHash`).
+		EnsureSessions() error
+		// SessionCreate inserts a new session; s.ID is set on success.
+		SessionCreate(s *Session) error
+		// SessionSave updates (or inserts) a session with exception
+		// to its data (see SessionUpdateData).
+		SessionSave(s *Session) error
+		// SessionUpdateData replaces the data of the session matching id
+		// with the result of update, given the data as stored, atomically
+		// so that concurrent updates are not lost.  Returns ErrNotFound
+		// if there is no such session or the error of update.
+		SessionUpdateData(id int64, update func(data string) (string, error)) error
+		// SessionByUser returns the first session owned by userID or ErrNotFound.
+		SessionByUser(userID int64) (Session, error)
+		// SessionByCookie returns the session matching client, host and
+		// sesshash (`Session.SessHash`) or ErrNotFound.
+		SessionByCookie(client, host, sesshash string) (Session, error)
+		// SessionByDevice returns the session matching device, host and userID
+		// or ErrNotFound.
+		SessionByDevice(device, host string, userID int64) (Session, error)
+		// SessionByID returns the session matching id or ErrNotFound.
+		SessionByID(id int64) (Session, error)
+		// SessionsByUser returns every session owned by userID.
+		SessionsByUser(userID int64) ([]Session, error)
+		// SessionList returns all sessions.
+		SessionList() ([]Session, error)
+		// SessionRevokeByUser expires every session owned by userID
+		// with exception to the session matching exceptID (which may be 0)
+		// and returns the number of sessions revoked.
+		SessionRevokeByUser(userID, exceptID int64) (int64, error)
+		// SessionPurge deletes sessions which expired prior to before
+		// and returns the number of rows deleted.
+		SessionPurge(before time.Time) (int64, error)
+	}
+	// ResetStore persists `PasswordReset` records.
+	ResetStore interface {
+		// EnsureResets creates the password_resets table if it does not exist.
+		EnsureResets() error
+		// ResetCreate inserts a new password reset.
+		ResetCreate(r *PasswordReset) error
+		// ResetByHash returns the password reset matching hash or ErrNotFound.
+		ResetByHash(hash string) (PasswordReset, error)
+		// ResetTake deletes the password reset matching hash and returns it.
+		// Only one caller may take a given reset; others get ErrNotFound.
+		ResetTake(hash string) (PasswordReset, error)
+		// ResetDeleteByUser deletes every password reset issued to userID.
+		ResetDeleteByUser(userID int64) error
+		// ResetPurge deletes password resets which expired prior to before
+		// and returns the number of rows deleted.
+		ResetPurge(before time.Time) (int64, error)
+	}
+	// ChallengeStore persists `Challenge` records.
+	ChallengeStore interface {
+		// EnsureChallenges creates the challenges table if it does not exist.
+		EnsureChallenges() error
+		// ChallengeCreate inserts a new challenge.
+		ChallengeCreate(c *Challenge) error
+		// ChallengeSave updates a challenge.
+		ChallengeSave(c *Challenge) error
+		// ChallengeByHash returns the challenge matching hash or ErrNotFound.
+		ChallengeByHash(hash string) (Challenge, error)
+		// ChallengeDelete deletes the challenge matching id;
+		// returns ErrNotFound if it was already deleted.
+		ChallengeDelete(id int64) error
+		// ChallengePurge deletes challenges which expired prior to before
+		// and returns the number of rows deleted.
+		ChallengePurge(before time.Time) (int64, error)
+	}
+	// RecoveryStore persists `RecoveryCode` records.
+	RecoveryStore interface {
+		// EnsureRecoveryCodes creates the recovery_codes table if it does not exist.
+		EnsureRecoveryCodes() error
+		// RecoveryReplace deletes the recovery codes of userID and
+		// inserts codes in their place.
+		RecoveryReplace(userID int64, codes []RecoveryCode) error
+		// RecoveryByUser returns the recovery codes of userID.
+		RecoveryByUser(userID int64) ([]RecoveryCode, error)
+		// RecoveryDelete deletes the recovery code matching id;
+		// returns ErrNotFound if it was already deleted.
+		RecoveryDelete(id int64) error
+	}
+	// ThrottleStore persists `Throttle` records.
+	ThrottleStore interface {
+		// EnsureThrottles creates the throttles table if it does not exist.
+		EnsureThrottles() error
+		// ThrottleByKey returns the throttle matching key or ErrNotFound.
+		ThrottleByKey(key string) (Throttle, error)
+		// ThrottleFail atomically increments the failures of key (creating
+		// it if need be) and sets its updated time to now.  If key was last
+		// updated before since, its failures start over at 1.
+		ThrottleFail(key string, since time.Time) error
+		// ThrottleClear deletes the throttle matching key.
+		ThrottleClear(key string) error
+		// ThrottlePurge deletes throttles last updated prior to before
+		// and returns the number of rows deleted.
+		ThrottlePurge(before time.Time) (int64, error)
+	}
+	// Store is the persistence backend a `Service` is configured with.
+	//
+	// `GormStore` is the default implementation; supply your own to
+	// `Service.Store` in order to swap backends.
+	Store interface {
+		UserStore
+		SessionStore
+		ResetStore
+		ChallengeStore
+		RecoveryStore
+		ThrottleStore
+	}
+)
+
+// storage returns the Store our service is configured with.
+// If none was provided, a `GormStore` is created on the data-source
+// supplied to `SetDefaults`.
+func storage() Store {
+	if service == nil {
+		service = DefaultService()
+	}
+	if service.Store == nil {
+		service.Store = NewGormStore(datasys, datasource)
+	}
+	return service.Store
+}
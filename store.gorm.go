@@ -0,0 +1,670 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// cols maps column names to values for use as a GORM condition.
+// Unlike raw SQL conditions, GORM quotes each column for the dialect
+// in use which is required for columns such as [cli-key] and [user].
+type cols = map[string]interface{}
+
+// GormStore is the default `Store` which persists users and sessions
+// to a database using GORM.
+//
+// The database is opened once (on first use) and its connection pool
+// is shared by every subsequent call.
+type GormStore struct {
+	sys         string
+	source      string
+	mu          sync.Mutex
+	db          *gorm.DB
+	shared      bool   // db was supplied by the host application
+	prefix      string // table-name prefix
+	maxOpen     int
+	maxIdle     int
+	maxLifetime time.Duration
+}
+
+// NewGormStore creates a GormStore on the given data-system and data-source;
+// see `SetDefaults`.
+//
+// The database is not opened until the store is first used.
+func NewGormStore(sys, source string) *GormStore {
+	return &GormStore{sys: sys, source: source, maxOpen: -1, maxIdle: -1, maxLifetime: -1}
+}
+
+// NewGormStoreDB creates a GormStore on a database that was already
+// opened by the host application.  Its logger, plugins and settings are
+// used as they are.
+//
+// prefix (which may be empty) is prepended to each table name such
+// that `users` becomes `<prefix>users`.
+//
+// Pool settings of db are left as they are unless `SetPool` is called.
+func NewGormStoreDB(db *gorm.DB, prefix string) *GormStore {
+	return &GormStore{db: db, shared: true, prefix: prefix, maxOpen: -1, maxIdle: -1, maxLifetime: -1}
+}
+
+// SetPool configures the connection pool of the underlying `sql.DB`.
+// Set a value to -1 to persist default(s).
+//
+// Settings supplied prior to the database being opened are applied
+// once it has been opened.
+//
+// *note*: an in-memory sqlite3 database exists per-connection so
+// maxOpen should be set to 1 when using one.
+func (s *GormStore) SetPool(maxOpen, maxIdle int, maxLifetime time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if maxOpen != -1 {
+		s.maxOpen = maxOpen
+	}
+	if maxIdle != -1 {
+		s.maxIdle = maxIdle
+	}
+	if maxLifetime != -1 {
+		s.maxLifetime = maxLifetime
+	}
+	if s.db == nil {
+		return nil
+	}
+	return s.applyPool()
+}
+
+// applyPool applies pool settings to the opened database.
+// The caller must hold s.mu.
+func (s *GormStore) applyPool() error {
+	sqldb, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	if s.maxOpen != -1 {
+		sqldb.SetMaxOpenConns(s.maxOpen)
+	}
+	if s.maxIdle != -1 {
+		sqldb.SetMaxIdleConns(s.maxIdle)
+	}
+	if s.maxLifetime != -1 {
+		sqldb.SetConnMaxLifetime(s.maxLifetime)
+	}
+	return nil
+}
+
+// DB returns the shared database, opening it if need be.
+func (s *GormStore) DB() (*gorm.DB, error) {
+	return s.open("")
+}
+
+// Close closes the underlying database.
+// The store will re-open the database if it is used again.
+//
+// A database supplied to `NewGormStoreDB` belongs to the host
+// application and is not closed.
+func (s *GormStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil || s.shared {
+		return nil
+	}
+	sqldb, err := s.db.DB()
+	s.db = nil
+	if err != nil {
+		return err
+	}
+	return sqldb.Close()
+}
+
+// open returns the shared database, loading it on first use,
+// and prints requested status on error.
+func (s *GormStore) open(format string, msg ...interface{}) (*gorm.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db != nil {
+		return s.db, nil
+	}
+	db, err := dbopen(s.sys, s.source)
+	if err != nil {
+		if format != "" {
+			fmt.Printf("well then: "+format, msg...)
+		}
+		fmt.Printf("error: %v\n", err)
+		return nil, err
+	}
+	s.db = db
+	if err = s.applyPool(); err != nil {
+		fmt.Printf("error(pool): %v\n", err)
+	}
+	return db, nil
+}
+
+// table scopes db to the (prefixed) table of model.
+func (s *GormStore) table(db *gorm.DB, model schema.Tabler) *gorm.DB {
+	return db.Table(s.prefix + model.TableName())
+}
+
+// ensure creates the (prefixed) table of model if not exist
+// and adds any of its columns missing from an existing table.
+func (s *GormStore) ensure(db *gorm.DB, model schema.Tabler) error {
+	tx := s.table(db, model)
+	m := tx.Migrator()
+	if !m.HasTable(model) {
+		return m.CreateTable(model)
+	}
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" || m.HasColumn(model, field.DBName) {
+			continue
+		}
+		if err := m.AddColumn(model, field.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gormError translates gorm.ErrRecordNotFound to ErrNotFound.
+func gormError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// EnsureUsers creates table [users] if not exist.
+func (s *GormStore) EnsureUsers() error {
+	db, err := s.open("error(ensure-table-users) loading db (perhaps expected)\n")
+	if err != nil {
+		return err
+	}
+	return s.ensure(db, &User{})
+}
+
+// UserByName gets a user by [name].
+func (s *GormStore) UserByName(name string) (User, error) {
+	u := User{}
+	db, err := s.open("error(user-by-name) loading database\n")
+	if err != nil {
+		return u, err
+	}
+	return u, gormError(s.table(db, &u).Where(cols{"user": name}).First(&u).Error)
+}
+
+// UserByID gets a user by [id].
+func (s *GormStore) UserByID(id int64) (User, error) {
+	u := User{}
+	db, err := s.open("error(user-by-id) loading database\n")
+	if err != nil {
+		return u, err
+	}
+	return u, gormError(s.table(db, &u).First(&u, id).Error)
+}
+
+// UserList gets all users.
+func (s *GormStore) UserList() ([]User, error) {
+	var users []User
+	db, err := s.open("error(user-get-list) loading database\n")
+	if err != nil {
+		return users, err
+	}
+	return users, s.table(db, &User{}).Find(&users).Error
+}
+
+// UserCreate inserts u into [users].
+func (s *GormStore) UserCreate(u *User) error {
+	db, err := s.open("error(user-create): loading database\n")
+	if err != nil {
+		return err
+	}
+	return s.table(db, u).Create(u).Error
+}
+
+// UserSave updates u in [users].
+func (s *GormStore) UserSave(u *User) error {
+	db, err := s.open("error(user-save): loading database\n")
+	if err != nil {
+		return err
+	}
+	return s.table(db, u).Save(u).Error
+}
+
+// UserDelete removes the user from [users] and its rows from [sessions],
+// [password_resets], [challenges] and [recovery_codes] in a single transaction.
+func (s *GormStore) UserDelete(id int64) error {
+	db, err := s.open("error(user-delete): loading database\n")
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := s.table(tx, &Session{}).Where(cols{"user_id": id}).Delete(&Session{}).Error; err != nil {
+			return err
+		}
+		if err := s.table(tx, &PasswordReset{}).Where(cols{"user_id": id}).Delete(&PasswordReset{}).Error; err != nil {
+			return err
+		}
+		if err := s.table(tx, &Challenge{}).Where(cols{"user_id": id}).Delete(&Challenge{}).Error; err != nil {
+			return err
+		}
+		if err := s.table(tx, &RecoveryCode{}).Where(cols{"user_id": id}).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return s.table(tx, &User{}).Delete(&User{}, id).Error
+	})
+}
+
+// EnsureSessions creates table [sessions] if not exist.
+func (s *GormStore) EnsureSessions() error {
+	db, err := s.open("error(ensure-table-sessions) loading db; (expected)\n")
+	if err != nil {
+		return err
+	}
+	if err := s.ensure(db, &Session{}); err != nil {
+		return err
+	}
+	return s.hashSessionIDs(db)
+}
+
+// hashSessionIDs replaces session IDs stored in clear (prior to
+// `Session.SessHash`) with their hash.  Only rows whose [sessid] is not
+// of a hash's length are loaded, and all are updated in one transaction.
+func (s *GormStore) hashSessionIDs(db *gorm.DB) error {
+	var sessions []Session
+	if err := s.table(db, &Session{}).Select("id", "sessid").
+		Where("LENGTH(sessid) <> ?", hex.EncodedLen(sha256.Size)).
+		Find(&sessions).Error; err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, x := range sessions {
+			if isHashedToken(x.SessHash) {
+				continue
+			}
+			if err := s.table(tx, &Session{}).Where(cols{"id": x.ID}).Update("sessid", hashToken(x.SessHash)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SessionCreate inserts sess into [sessions].
+func (s *GormStore) SessionCreate(sess *Session) error {
+	db, err := s.open("error(user-create-session) loading database\n")
+	if err != nil {
+		return err
+	}
+	return s.table(db, sess).Create(sess).Error
+}
+
+// SessionSave updates sess in [sessions].
+func (s *GormStore) SessionSave(sess *Session) error {
+	db, err := s.open("error(validate-session) loading database\n")
+	if err != nil {
+		return err
+	}
+	return s.table(db, sess).Omit("data").Save(sess).Error
+}
+
+// SessionUpdateData updates [data] of the session matching [id] in a
+// transaction, having selected the row FOR UPDATE (sqlite3 locks the
+// database on write instead).
+func (s *GormStore) SessionUpdateData(id int64, update func(data string) (string, error)) error {
+	db, err := s.open("error(session-data) loading database\n")
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		sess := Session{}
+		if err := s.table(tx, &sess).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "data").Where(cols{"id": id}).First(&sess).Error; err != nil {
+			return gormError(err)
+		}
+		data, err := update(sess.Data)
+		if err != nil {
+			return err
+		}
+		return s.table(tx, &sess).Where(cols{"id": id}).Update("data", data).Error
+	})
+}
+
+// SessionByUser gets the first session matching [user_id].
+func (s *GormStore) SessionByUser(userID int64) (Session, error) {
+	sess := Session{}
+	db, err := s.open("error(validate-session) loading database\n")
+	if err != nil {
+		return sess, err
+	}
+	return sess, gormError(s.table(db, &sess).Where(cols{"user_id": userID}).First(&sess).Error)
+}
+
+// SessionByCookie gets the session matching [cli-key], [host] and [sessid]
+// (which holds the hash of the session ID).
+func (s *GormStore) SessionByCookie(client, host, sesshash string) (Session, error) {
+	sess := Session{}
+	db, err := s.open("error(validate-session) loading database\n")
+	if err != nil {
+		return sess, err
+	}
+	return sess, gormError(s.table(db, &sess).Where(cols{"cli-key": client, "host": host, "sessid": sesshash}).First(&sess).Error)
+}
+
+// SessionByDevice gets the session matching [device], [host] and [user_id].
+func (s *GormStore) SessionByDevice(device, host string, userID int64) (Session, error) {
+	sess := Session{}
+	db, err := s.open("error(validate-session) loading database\n")
+	if err != nil {
+		return sess, err
+	}
+	return sess, gormError(s.table(db, &sess).Where(cols{"device": device, "host": host, "user_id": userID}).First(&sess).Error)
+}
+
+// SessionByID gets the session matching [id].
+func (s *GormStore) SessionByID(id int64) (Session, error) {
+	sess := Session{}
+	db, err := s.open("error(validate-session) loading database\n")
+	if err != nil {
+		return sess, err
+	}
+	return sess, gormError(s.table(db, &sess).First(&sess, id).Error)
+}
+
+// SessionsByUser gets all sessions matching [user_id].
+func (s *GormStore) SessionsByUser(userID int64) ([]Session, error) {
+	sessions := []Session{}
+	db, err := s.open("error(validate-session) loading database\n")
+	if err != nil {
+		return sessions, err
+	}
+	return sessions, s.table(db, &Session{}).Where(cols{"user_id": userID}).Find(&sessions).Error
+}
+
+// SessionList gets all sessions.
+func (s *GormStore) SessionList() ([]Session, error) {
+	sessions := []Session{}
+	db, err := s.open("error(session-cli-list) loading db\n")
+	if err != nil {
+		return sessions, err
+	}
+	return sessions, s.table(db, &Session{}).Find(&sessions).Error
+}
+
+// SessionRevokeByUser sets [expires] to now on rows matching [user_id]
+// where [id] <> exceptID.
+func (s *GormStore) SessionRevokeByUser(userID, exceptID int64) (int64, error) {
+	db, err := s.open("error(session-revoke) loading db\n")
+	if err != nil {
+		return 0, err
+	}
+	tx := s.table(db, &Session{}).
+		Where(cols{"user_id": userID}).
+		Where("id <> ?", exceptID).
+		Where("expires > ?", time.Now()).
+		Updates(cols{"expires": time.Now(), "keep-alive": false})
+	return tx.RowsAffected, tx.Error
+}
+
+// SessionPurge deletes rows from [sessions] where [expires] < before.
+func (s *GormStore) SessionPurge(before time.Time) (int64, error) {
+	db, err := s.open("error(session-purge) loading db\n")
+	if err != nil {
+		return 0, err
+	}
+	tx := s.table(db, &Session{}).Where("expires < ?", before).Delete(&Session{})
+	return tx.RowsAffected, tx.Error
+}
+
+// EnsureResets creates table [password_resets] if not exist.
+func (s *GormStore) EnsureResets() error {
+	db, err := s.open("error(ensure-table-resets) loading db; (expected)\n")
+	if err != nil {
+		return err
+	}
+	return s.ensure(db, &PasswordReset{})
+}
+
+// ResetCreate inserts r into [password_resets].
+func (s *GormStore) ResetCreate(r *PasswordReset) error {
+	db, err := s.open("error(reset-create) loading database\n")
+	if err != nil {
+		return err
+	}
+	return s.table(db, r).Create(r).Error
+}
+
+// ResetByHash gets the password reset matching [hash].
+func (s *GormStore) ResetByHash(hash string) (PasswordReset, error) {
+	r := PasswordReset{}
+	db, err := s.open("error(reset-by-hash) loading database\n")
+	if err != nil {
+		return r, err
+	}
+	return r, gormError(s.table(db, &r).Where(cols{"hash": hash}).First(&r).Error)
+}
+
+// ResetTake deletes the row matching [hash] from [password_resets]
+// and returns it.
+func (s *GormStore) ResetTake(hash string) (PasswordReset, error) {
+	r := PasswordReset{}
+	db, err := s.open("error(reset-take) loading database\n")
+	if err != nil {
+		return r, err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := s.table(tx, &r).Where(cols{"hash": hash}).First(&r).Error; err != nil {
+			return gormError(err)
+		}
+		del := s.table(tx, &r).Delete(&PasswordReset{}, r.ID)
+		if del.Error == nil && del.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return del.Error
+	})
+	return r, err
+}
+
+// ResetDeleteByUser deletes rows from [password_resets] matching [user_id].
+func (s *GormStore) ResetDeleteByUser(userID int64) error {
+	db, err := s.open("error(reset-delete) loading database\n")
+	if err != nil {
+		return err
+	}
+	return s.table(db, &PasswordReset{}).Where(cols{"user_id": userID}).Delete(&PasswordReset{}).Error
+}
+
+// ResetPurge deletes rows from [password_resets] where [expires] < before.
+func (s *GormStore) ResetPurge(before time.Time) (int64, error) {
+	db, err := s.open("error(reset-purge) loading db\n")
+	if err != nil {
+		return 0, err
+	}
+	tx := s.table(db, &PasswordReset{}).Where("expires < ?", before).Delete(&PasswordReset{})
+	return tx.RowsAffected, tx.Error
+}
+
+// EnsureChallenges creates table [challenges] if not exist.
+func (s *GormStore) EnsureChallenges() error {
+	db, err := s.open("error(ensure-table-challenges) loading db; (expected)\n")
+	if err != nil {
+		return err
+	}
+	return s.ensure(db, &Challenge{})
+}
+
+// ChallengeCreate inserts c into [challenges].
+func (s *GormStore) ChallengeCreate(c *Challenge) error {
+	db, err := s.open("error(challenge-create) loading database\n")
+	if err != nil {
+		return err
+	}
+	return s.table(db, c).Create(c).Error
+}
+
+// ChallengeSave updates c in [challenges].
+func (s *GormStore) ChallengeSave(c *Challenge) error {
+	db, err := s.open("error(challenge-save) loading database\n")
+	if err != nil {
+		return err
+	}
+	return s.table(db, c).Save(c).Error
+}
+
+// ChallengeByHash gets the challenge matching [hash].
+func (s *GormStore) ChallengeByHash(hash string) (Challenge, error) {
+	c := Challenge{}
+	db, err := s.open("error(challenge-by-hash) loading database\n")
+	if err != nil {
+		return c, err
+	}
+	return c, gormError(s.table(db, &c).Where(cols{"hash": hash}).First(&c).Error)
+}
+
+// ChallengeDelete deletes the row matching [id] from [challenges].
+func (s *GormStore) ChallengeDelete(id int64) error {
+	db, err := s.open("error(challenge-delete) loading database\n")
+	if err != nil {
+		return err
+	}
+	tx := s.table(db, &Challenge{}).Delete(&Challenge{}, id)
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return tx.Error
+}
+
+// ChallengePurge deletes rows from [challenges] where [expires] < before.
+func (s *GormStore) ChallengePurge(before time.Time) (int64, error) {
+	db, err := s.open("error(challenge-purge) loading db\n")
+	if err != nil {
+		return 0, err
+	}
+	tx := s.table(db, &Challenge{}).Where("expires < ?", before).Delete(&Challenge{})
+	return tx.RowsAffected, tx.Error
+}
+
+// EnsureRecoveryCodes creates table [recovery_codes] if not exist.
+func (s *GormStore) EnsureRecoveryCodes() error {
+	db, err := s.open("error(ensure-table-recovery-codes) loading db; (expected)\n")
+	if err != nil {
+		return err
+	}
+	return s.ensure(db, &RecoveryCode{})
+}
+
+// RecoveryReplace deletes rows from [recovery_codes] matching [user_id]
+// and inserts codes in a single transaction.
+func (s *GormStore) RecoveryReplace(userID int64, codes []RecoveryCode) error {
+	db, err := s.open("error(recovery-replace) loading database\n")
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := s.table(tx, &RecoveryCode{}).Where(cols{"user_id": userID}).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		return s.table(tx, &RecoveryCode{}).Create(&codes).Error
+	})
+}
+
+// RecoveryByUser gets all rows of [recovery_codes] matching [user_id].
+func (s *GormStore) RecoveryByUser(userID int64) ([]RecoveryCode, error) {
+	codes := []RecoveryCode{}
+	db, err := s.open("error(recovery-by-user) loading database\n")
+	if err != nil {
+		return codes, err
+	}
+	return codes, s.table(db, &RecoveryCode{}).Where(cols{"user_id": userID}).Find(&codes).Error
+}
+
+// RecoveryDelete deletes the row matching [id] from [recovery_codes].
+func (s *GormStore) RecoveryDelete(id int64) error {
+	db, err := s.open("error(recovery-delete) loading database\n")
+	if err != nil {
+		return err
+	}
+	tx := s.table(db, &RecoveryCode{}).Delete(&RecoveryCode{}, id)
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return tx.Error
+}
+
+// EnsureThrottles creates table [throttles] if not exist.
+func (s *GormStore) EnsureThrottles() error {
+	db, err := s.open("error(ensure-table-throttles) loading db; (expected)\n")
+	if err != nil {
+		return err
+	}
+	return s.ensure(db, &Throttle{})
+}
+
+// ThrottleByKey gets the throttle matching [throttle_key].
+func (s *GormStore) ThrottleByKey(key string) (Throttle, error) {
+	t := Throttle{}
+	db, err := s.open("error(throttle-by-key) loading database\n")
+	if err != nil {
+		return t, err
+	}
+	return t, gormError(s.table(db, &t).Where(cols{"throttle_key": key}).First(&t).Error)
+}
+
+// ThrottleFail increments [failures] of the row matching [throttle_key]
+// or inserts it.
+func (s *GormStore) ThrottleFail(key string, since time.Time) error {
+	db, err := s.open("error(throttle-fail) loading database\n")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	update := func() *gorm.DB {
+		return s.table(db, &Throttle{}).Where(cols{"throttle_key": key}).Updates(cols{
+			"failures": gorm.Expr("CASE WHEN updated < ? THEN 1 ELSE failures + 1 END", since),
+			"updated":  now,
+		})
+	}
+	tx := update()
+	if tx.Error != nil || tx.RowsAffected > 0 {
+		return tx.Error
+	}
+	if err = s.table(db, &Throttle{}).Create(&Throttle{Key: key, Failures: 1, Updated: now}).Error; err != nil {
+		// lost a race to create the row; count against it instead.
+		return update().Error
+	}
+	return nil
+}
+
+// ThrottleClear deletes the row matching [throttle_key] from [throttles].
+func (s *GormStore) ThrottleClear(key string) error {
+	db, err := s.open("error(throttle-clear) loading database\n")
+	if err != nil {
+		return err
+	}
+	return s.table(db, &Throttle{}).Where(cols{"throttle_key": key}).Delete(&Throttle{}).Error
+}
+
+// ThrottlePurge deletes rows from [throttles] where [updated] < before.
+func (s *GormStore) ThrottlePurge(before time.Time) (int64, error) {
+	db, err := s.open("error(throttle-purge) loading db\n")
+	if err != nil {
+		return 0, err
+	}
+	tx := s.table(db, &Throttle{}).Where("updated < ?", before).Delete(&Throttle{})
+	return tx.RowsAffected, tx.Error
+}
@@ -0,0 +1,539 @@
+package session
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestGormStore returns a GormStore on a fresh sqlite3 file with its
+// tables created and a func which removes it.
+func newTestGormStore(t *testing.T) (*GormStore, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewGormStore("sqlite", filepath.Join(dir, "data.db"))
+	for _, ensure := range []func() error{s.EnsureUsers, s.EnsureSessions, s.EnsureResets, s.EnsureChallenges, s.EnsureRecoveryCodes, s.EnsureThrottles} {
+		if err := ensure(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGormStoreUsers(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	u := User{Name: "admin1", Hash: "h"}
+	if err := s.UserCreate(&u); err != nil || u.ID == 0 {
+		t.Fatalf("UserCreate: %v, id %d", err, u.ID)
+	}
+	if x, err := s.UserByName("admin1"); err != nil || x.ID != u.ID {
+		t.Errorf("UserByName = %+v, %v", x, err)
+	}
+	if x, err := s.UserByID(u.ID); err != nil || x.Name != "admin1" {
+		t.Errorf("UserByID = %+v, %v", x, err)
+	}
+	if _, err := s.UserByName("nobody1"); err != ErrNotFound {
+		t.Errorf("UserByName(unknown) error = %v", err)
+	}
+	if _, err := s.UserByID(u.ID + 1); err != ErrNotFound {
+		t.Errorf("UserByID(unknown) error = %v", err)
+	}
+	if list, err := s.UserList(); err != nil || len(list) != 1 {
+		t.Errorf("UserList = %+v, %v", list, err)
+	}
+	u.Hash = "h2"
+	if err := s.UserSave(&u); err != nil {
+		t.Fatal(err)
+	}
+	if x, _ := s.UserByID(u.ID); x.Hash != "h2" {
+		t.Errorf("UserSave stored hash %q", x.Hash)
+	}
+}
+
+func TestGormStoreSessions(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	now := time.Now()
+	sess := Session{UserID: 3, SessHash: "sid", Host: "app", Client: "cli", Device: "dev", Created: now, Expires: now.Add(time.Hour)}
+	if err := s.SessionCreate(&sess); err != nil || sess.ID == 0 {
+		t.Fatalf("SessionCreate: %v, id %d", err, sess.ID)
+	}
+	if x, err := s.SessionByCookie("cli", "app", "sid"); err != nil || x.ID != sess.ID {
+		t.Errorf("SessionByCookie = %+v, %v", x, err)
+	}
+	if _, err := s.SessionByCookie("other", "app", "sid"); err != ErrNotFound {
+		t.Errorf("SessionByCookie(other client) error = %v", err)
+	}
+	if x, err := s.SessionByDevice("dev", "app", 3); err != nil || x.ID != sess.ID {
+		t.Errorf("SessionByDevice = %+v, %v", x, err)
+	}
+	if x, err := s.SessionByID(sess.ID); err != nil || x.SessHash != "sid" {
+		t.Errorf("SessionByID = %+v, %v", x, err)
+	}
+	sess.KeepAlive = true
+	if err := s.SessionSave(&sess); err != nil {
+		t.Fatal(err)
+	}
+	if x, err := s.SessionByUser(3); err != nil || !x.KeepAlive {
+		t.Errorf("SessionByUser = %+v, %v", x, err)
+	}
+	if list, err := s.SessionList(); err != nil || len(list) != 1 {
+		t.Errorf("SessionList = %+v, %v", list, err)
+	}
+	if list, err := s.SessionsByUser(3); err != nil || len(list) != 1 {
+		t.Errorf("SessionsByUser = %+v, %v", list, err)
+	}
+	if err := s.SessionUpdateData(sess.ID, func(string) (string, error) { return `{"a":1}`, nil }); err != nil {
+		t.Fatal(err)
+	}
+	sess.Name = "phone" // SessionSave leaves the data be.
+	if err := s.SessionSave(&sess); err != nil {
+		t.Fatal(err)
+	}
+	if x, err := s.SessionByID(sess.ID); err != nil || x.Data != `{"a":1}` || x.Name != "phone" {
+		t.Errorf("SessionByID after SessionUpdateData = %+v, %v", x, err)
+	}
+}
+
+func TestGormStoreSessionUpdateData(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	s.SetPool(1, -1, -1)
+	sess := Session{UserID: 1}
+	if err := s.SessionCreate(&sess); err != nil {
+		t.Fatal(err)
+	}
+	// each routine reads, then writes, a count; none may be lost.
+	const n = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.SessionUpdateData(sess.ID, func(data string) (string, error) {
+				count, _ := strconv.Atoi(data)
+				time.Sleep(time.Millisecond)
+				return strconv.Itoa(count + 1), nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if x, _ := s.SessionByID(sess.ID); x.Data != strconv.Itoa(n) {
+		t.Errorf("data = %q, want %d", x.Data, n)
+	}
+
+	failed := errors.New("failed")
+	if err := s.SessionUpdateData(sess.ID, func(string) (string, error) { return "", failed }); err != failed {
+		t.Errorf("SessionUpdateData error = %v, want %v", err, failed)
+	}
+	if x, _ := s.SessionByID(sess.ID); x.Data != strconv.Itoa(n) {
+		t.Errorf("data after a failed update = %q", x.Data)
+	}
+	if err := s.SessionUpdateData(sess.ID+1, func(data string) (string, error) { return data, nil }); err != ErrNotFound {
+		t.Errorf("SessionUpdateData of no session = %v", err)
+	}
+}
+
+func TestGormStoreEnsureAddsColumns(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	db, err := s.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a sessions table created before [device], [agent] and [name].
+	if err := db.Migrator().DropTable("sessions"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("CREATE TABLE sessions (id integer primary key, user_id integer, sessid text)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnsureSessions(); err != nil {
+		t.Fatal(err)
+	}
+	for _, col := range []string{"device", "agent", "name", "expires"} {
+		if !db.Migrator().HasColumn(&Session{}, col) {
+			t.Errorf("column %s not added", col)
+		}
+	}
+}
+
+func TestGormStoreHashSessionIDs(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	db, err := s.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := hashToken("sid2")
+	// session IDs stored in clear, before [sessid] held their hash.
+	for id, sessid := range map[int64]string{1: "sid1", 2: hashed} {
+		if err := db.Exec("INSERT INTO sessions (id, user_id, sessid, host, created, expires, `cli-key`) VALUES (?, 1, ?, 'app', ?, ?, 'cli')", id, sessid, time.Now(), time.Now()).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ { // a second run leaves hashes be.
+		if err := s.EnsureSessions(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, want := range map[int64]string{1: hashToken("sid1"), 2: hashed} {
+		if x, err := s.SessionByID(id); err != nil || x.SessHash != want {
+			t.Errorf("session %d: %+v, %v; want hash %s", id, x, err, want)
+		}
+	}
+	if x, err := s.SessionByCookie("cli", "app", hashToken("sid1")); err != nil || x.ID != 1 {
+		t.Errorf("SessionByCookie = %+v, %v", x, err)
+	}
+}
+
+func TestGormStorePool(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	db, err := s.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := s.DB(); again != db {
+		t.Error("DB opened the database twice")
+	}
+	if err := s.SetPool(3, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	sqldb, _ := db.DB()
+	if n := sqldb.Stats().MaxOpenConnections; n != 3 {
+		t.Errorf("MaxOpenConnections = %d, want 3", n)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqldb.Ping(); err == nil {
+		t.Error("Close left the database open")
+	}
+	if _, err := s.UserList(); err != nil {
+		t.Errorf("UserList after Close: %v", err)
+	}
+
+	db, _ = s.DB()
+	shared := NewGormStoreDB(db, "")
+	shared.Close()
+	if sqldb, _ = db.DB(); sqldb.Ping() != nil {
+		t.Error("Close closed a database of the host application")
+	}
+}
+
+func TestGormStorePrefix(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	db, err := s.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewGormStoreDB(db, "auth_")
+	if err := p.EnsureUsers(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.EnsureSessions(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"auth_users", "auth_sessions"} {
+		if !db.Migrator().HasTable(name) {
+			t.Errorf("table %s not created", name)
+		}
+	}
+	u := User{Name: "admin1"}
+	if err := p.UserCreate(&u); err != nil {
+		t.Fatal(err)
+	}
+	if x, err := p.UserByName("admin1"); err != nil || x.ID != u.ID {
+		t.Errorf("prefixed UserByName = %+v, %v", x, err)
+	}
+	if _, err := s.UserByName("admin1"); err != ErrNotFound {
+		t.Errorf("user stored outside of the prefixed table: %v", err)
+	}
+	sess := Session{UserID: u.ID, SessHash: "sid", Host: "app", Client: "cli"}
+	if err := p.SessionCreate(&sess); err != nil {
+		t.Fatal(err)
+	}
+	if x, err := p.SessionByCookie("cli", "app", "sid"); err != nil || x.ID != sess.ID {
+		t.Errorf("prefixed SessionByCookie = %+v, %v", x, err)
+	}
+}
+
+func TestGormStoreUserDelete(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	users := []User{{Name: "admin1"}, {Name: "admin2"}}
+	for i := range users {
+		if err := s.UserCreate(&users[i]); err != nil {
+			t.Fatal(err)
+		}
+		for _, sid := range []string{"a", "b"} {
+			sess := Session{UserID: users[i].ID, SessHash: sid + users[i].Name}
+			if err := s.SessionCreate(&sess); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.ResetCreate(&PasswordReset{UserID: users[i].ID, Hash: users[i].Name}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ChallengeCreate(&Challenge{UserID: users[i].ID, Hash: users[i].Name}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RecoveryReplace(users[i].ID, []RecoveryCode{{UserID: users[i].ID, Hash: users[i].Name}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.UserDelete(users[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UserByID(users[0].ID); err != ErrNotFound {
+		t.Errorf("deleted user remains: %v", err)
+	}
+	if _, err := s.SessionByUser(users[0].ID); err != ErrNotFound {
+		t.Errorf("deleted user's session remains: %v", err)
+	}
+	if list, _ := s.SessionList(); len(list) != 2 {
+		t.Errorf("%d sessions remain, want the other user's 2", len(list))
+	}
+	if _, err := s.ResetByHash("admin1"); err != ErrNotFound {
+		t.Errorf("deleted user's reset remains: %v", err)
+	}
+	if _, err := s.ResetByHash("admin2"); err != nil {
+		t.Errorf("other user's reset: %v", err)
+	}
+	if _, err := s.ChallengeByHash("admin1"); err != ErrNotFound {
+		t.Errorf("deleted user's challenge remains: %v", err)
+	}
+	if _, err := s.ChallengeByHash("admin2"); err != nil {
+		t.Errorf("other user's challenge: %v", err)
+	}
+	if list, _ := s.RecoveryByUser(users[0].ID); len(list) != 0 {
+		t.Errorf("deleted user's recovery codes remain: %+v", list)
+	}
+	if list, _ := s.RecoveryByUser(users[1].ID); len(list) != 1 {
+		t.Errorf("other user's recovery codes: %+v", list)
+	}
+}
+
+func TestGormStoreSessionPurge(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	now := time.Now()
+	for _, exp := range []time.Time{now.Add(-2 * time.Hour), now.Add(-time.Minute), now.Add(time.Hour)} {
+		if err := s.SessionCreate(&Session{Expires: exp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := s.SessionPurge(now.Add(-time.Hour)); n != 1 || err != nil {
+		t.Errorf("SessionPurge = %d, %v; want 1, nil", n, err)
+	}
+	if list, _ := s.SessionList(); len(list) != 2 {
+		t.Errorf("%d sessions remain, want 2", len(list))
+	}
+}
+
+func TestGormStorePurge(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	old, now := time.Now().Add(-2*time.Hour), time.Now()
+	for hash, exp := range map[string]time.Time{"old": old, "new": now.Add(time.Hour)} {
+		if err := s.ResetCreate(&PasswordReset{UserID: 1, Hash: hash, Expires: exp}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ChallengeCreate(&Challenge{UserID: 1, Hash: hash, Expires: exp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.ThrottleFail("cli:x", old)
+	before := now.Add(-time.Hour)
+	if n, err := s.ResetPurge(before); n != 1 || err != nil {
+		t.Errorf("ResetPurge = %d, %v; want 1, nil", n, err)
+	}
+	if n, err := s.ChallengePurge(before); n != 1 || err != nil {
+		t.Errorf("ChallengePurge = %d, %v; want 1, nil", n, err)
+	}
+	if n, err := s.ThrottlePurge(before); n != 0 || err != nil {
+		t.Errorf("ThrottlePurge of a recent throttle = %d, %v", n, err)
+	}
+	if n, err := s.ThrottlePurge(now.Add(time.Minute)); n != 1 || err != nil {
+		t.Errorf("ThrottlePurge = %d, %v; want 1, nil", n, err)
+	}
+	if _, err := s.ResetByHash("new"); err != nil {
+		t.Errorf("unexpired reset purged: %v", err)
+	}
+}
+
+func TestGormStoreSessionRevokeByUser(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	now := time.Now()
+	sessions := []Session{
+		{UserID: 1, KeepAlive: true, Expires: now.Add(time.Hour)},
+		{UserID: 1, KeepAlive: true, Expires: now.Add(time.Hour)}, // kept
+		{UserID: 1, Expires: now.Add(-time.Hour)},                 // already expired
+		{UserID: 2, KeepAlive: true, Expires: now.Add(time.Hour)}, // another user
+	}
+	for i := range sessions {
+		if err := s.SessionCreate(&sessions[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := s.SessionRevokeByUser(1, sessions[1].ID); n != 1 || err != nil {
+		t.Fatalf("SessionRevokeByUser = %d, %v; want 1, nil", n, err)
+	}
+	for i, want := range []bool{false, true, false, true} {
+		x, err := s.SessionByID(sessions[i].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid := x.Expires.After(time.Now()); valid != want || x.KeepAlive != want {
+			t.Errorf("session %d: valid %v, keep-alive %v; want %v", i, valid, x.KeepAlive, want)
+		}
+	}
+	if n, _ := s.SessionRevokeByUser(1, 0); n != 1 {
+		t.Errorf("SessionRevokeByUser without exception revoked %d, want 1", n)
+	}
+}
+
+func TestGormStoreResets(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	for _, r := range []PasswordReset{{UserID: 1, Hash: "h1"}, {UserID: 1, Hash: "h2"}, {UserID: 2, Hash: "h3"}} {
+		if err := s.ResetCreate(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r, err := s.ResetByHash("h1"); err != nil || r.UserID != 1 {
+		t.Errorf("ResetByHash = %+v, %v", r, err)
+	}
+	if r, err := s.ResetTake("h1"); err != nil || r.UserID != 1 {
+		t.Errorf("ResetTake = %+v, %v", r, err)
+	}
+	if _, err := s.ResetTake("h1"); err != ErrNotFound {
+		t.Errorf("ResetTake of a taken reset: %v", err)
+	}
+	if err := s.ResetDeleteByUser(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ResetByHash("h2"); err != ErrNotFound {
+		t.Errorf("ResetDeleteByUser left h2: %v", err)
+	}
+	if _, err := s.ResetByHash("h3"); err != nil {
+		t.Errorf("ResetDeleteByUser removed another user's reset: %v", err)
+	}
+}
+
+func TestGormStoreChallenges(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	c := Challenge{UserID: 1, Hash: "h1", KeepAlive: true}
+	if err := s.ChallengeCreate(&c); err != nil {
+		t.Fatal(err)
+	}
+	c.Attempts = 2
+	if err := s.ChallengeSave(&c); err != nil {
+		t.Fatal(err)
+	}
+	if x, err := s.ChallengeByHash("h1"); err != nil || x.Attempts != 2 || !x.KeepAlive {
+		t.Errorf("ChallengeByHash = %+v, %v", x, err)
+	}
+	if err := s.ChallengeDelete(c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ChallengeDelete(c.ID); err != ErrNotFound {
+		t.Errorf("ChallengeDelete of a deleted challenge: %v", err)
+	}
+}
+
+func TestGormStoreRecoveryCodes(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	batch := []RecoveryCode{{UserID: 1, Hash: "h1"}, {UserID: 1, Hash: "h2"}}
+	if err := s.RecoveryReplace(1, batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecoveryReplace(2, []RecoveryCode{{UserID: 2, Hash: "h3"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecoveryReplace(1, []RecoveryCode{{UserID: 1, Hash: "h4"}}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.RecoveryByUser(1)
+	if err != nil || len(list) != 1 || list[0].Hash != "h4" {
+		t.Fatalf("RecoveryByUser after replace = %+v, %v", list, err)
+	}
+	if err := s.RecoveryDelete(list[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecoveryDelete(list[0].ID); err != ErrNotFound {
+		t.Errorf("RecoveryDelete of a deleted code: %v", err)
+	}
+	if err := s.RecoveryReplace(2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := s.RecoveryByUser(2); len(list) != 0 {
+		t.Errorf("RecoveryReplace(nil) left %+v", list)
+	}
+}
+
+func TestGormStoreThrottleFail(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	// one connection serializes statements (as sqlite3 would anyway)
+	// while the routines still interleave between them.
+	s.SetPool(1, -1, -1)
+	const n = 16
+	since := time.Now().Add(-time.Hour)
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.ThrottleFail("cli:x", since)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("ThrottleFail: %v", err)
+		}
+	}
+	if x, err := s.ThrottleByKey("cli:x"); err != nil || x.Failures != n {
+		t.Errorf("ThrottleByKey = %+v, %v; want %d failures", x, err, n)
+	}
+	// a throttle last updated before since starts over.
+	if err := s.ThrottleFail("cli:x", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if x, _ := s.ThrottleByKey("cli:x"); x.Failures != 1 {
+		t.Errorf("decayed throttle has %d failures, want 1", x.Failures)
+	}
+	if err := s.ThrottleClear("cli:x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ThrottleByKey("cli:x"); err != ErrNotFound {
+		t.Errorf("ThrottleByKey after clear: %v", err)
+	}
+}
@@ -0,0 +1,423 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// memStore is an in-memory `Store` for tests.
+type memStore struct {
+	mu         sync.Mutex
+	next       int64
+	users      map[int64]User
+	sessions   map[int64]Session
+	resets     map[int64]PasswordReset
+	challenges map[int64]Challenge
+	recovery   map[int64]RecoveryCode
+	throttles  map[string]Throttle
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		users:      map[int64]User{},
+		sessions:   map[int64]Session{},
+		resets:     map[int64]PasswordReset{},
+		challenges: map[int64]Challenge{},
+		recovery:   map[int64]RecoveryCode{},
+		throttles:  map[string]Throttle{},
+	}
+}
+
+func (m *memStore) id() int64 {
+	m.next++
+	return m.next
+}
+
+func (m *memStore) EnsureUsers() error { return nil }
+
+func (m *memStore) UserByName(name string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (m *memStore) UserByID(id int64) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[id]; ok {
+		return u, nil
+	}
+	return User{}, ErrNotFound
+}
+
+func (m *memStore) UserList() ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []User
+	for _, u := range m.users {
+		list = append(list, u)
+	}
+	return list, nil
+}
+
+func (m *memStore) UserCreate(u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u.ID = m.id()
+	m.users[u.ID] = *u
+	return nil
+}
+
+func (m *memStore) UserSave(u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[u.ID] = *u
+	return nil
+}
+
+func (m *memStore) UserDelete(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, x := range m.sessions {
+		if x.UserID == id {
+			delete(m.sessions, k)
+		}
+	}
+	for k, x := range m.resets {
+		if x.UserID == id {
+			delete(m.resets, k)
+		}
+	}
+	for k, x := range m.challenges {
+		if x.UserID == id {
+			delete(m.challenges, k)
+		}
+	}
+	for k, x := range m.recovery {
+		if x.UserID == id {
+			delete(m.recovery, k)
+		}
+	}
+	delete(m.users, id)
+	return nil
+}
+
+func (m *memStore) EnsureSessions() error { return nil }
+
+func (m *memStore) SessionCreate(s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.ID = m.id()
+	m.sessions[s.ID] = *s
+	return nil
+}
+
+func (m *memStore) SessionSave(s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s.ID == 0 {
+		s.ID = m.id()
+	}
+	x := *s
+	x.SessID = ""
+	x.Data = m.sessions[s.ID].Data
+	m.sessions[s.ID] = x
+	return nil
+}
+
+func (m *memStore) SessionUpdateData(id int64, update func(data string) (string, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	x, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	data, err := update(x.Data)
+	if err != nil {
+		return err
+	}
+	x.Data = data
+	m.sessions[id] = x
+	return nil
+}
+
+// sessionWhere returns the first session matching fn.
+func (m *memStore) sessionWhere(fn func(Session) bool) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, x := range m.sessions {
+		if fn(x) {
+			return x, nil
+		}
+	}
+	return Session{}, ErrNotFound
+}
+
+func (m *memStore) SessionByUser(userID int64) (Session, error) {
+	return m.sessionWhere(func(x Session) bool { return x.UserID == userID })
+}
+
+func (m *memStore) SessionByCookie(client, host, sesshash string) (Session, error) {
+	return m.sessionWhere(func(x Session) bool {
+		return x.Client == client && x.Host == host && x.SessHash == sesshash
+	})
+}
+
+func (m *memStore) SessionByDevice(device, host string, userID int64) (Session, error) {
+	return m.sessionWhere(func(x Session) bool {
+		return x.Device == device && x.Host == host && x.UserID == userID
+	})
+}
+
+func (m *memStore) SessionByID(id int64) (Session, error) {
+	return m.sessionWhere(func(x Session) bool { return x.ID == id })
+}
+
+func (m *memStore) SessionsByUser(userID int64) ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []Session
+	for _, x := range m.sessions {
+		if x.UserID == userID {
+			list = append(list, x)
+		}
+	}
+	return list, nil
+}
+
+func (m *memStore) SessionList() ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []Session
+	for _, x := range m.sessions {
+		list = append(list, x)
+	}
+	return list, nil
+}
+
+func (m *memStore) SessionRevokeByUser(userID, exceptID int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	now := time.Now()
+	for k, x := range m.sessions {
+		if x.UserID == userID && x.ID != exceptID && x.Expires.After(now) {
+			x.Expires, x.KeepAlive = now, false
+			m.sessions[k] = x
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) SessionPurge(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for k, x := range m.sessions {
+		if x.Expires.Before(before) {
+			delete(m.sessions, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) EnsureResets() error { return nil }
+
+func (m *memStore) ResetCreate(r *PasswordReset) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.ID = m.id()
+	m.resets[r.ID] = *r
+	return nil
+}
+
+func (m *memStore) ResetByHash(hash string) (PasswordReset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, x := range m.resets {
+		if x.Hash == hash {
+			return x, nil
+		}
+	}
+	return PasswordReset{}, ErrNotFound
+}
+
+func (m *memStore) ResetTake(hash string) (PasswordReset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, x := range m.resets {
+		if x.Hash == hash {
+			delete(m.resets, k)
+			return x, nil
+		}
+	}
+	return PasswordReset{}, ErrNotFound
+}
+
+func (m *memStore) ResetDeleteByUser(userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, x := range m.resets {
+		if x.UserID == userID {
+			delete(m.resets, k)
+		}
+	}
+	return nil
+}
+
+func (m *memStore) ResetPurge(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for k, x := range m.resets {
+		if x.Expires.Before(before) {
+			delete(m.resets, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) EnsureChallenges() error { return nil }
+
+func (m *memStore) ChallengeCreate(c *Challenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c.ID = m.id()
+	m.challenges[c.ID] = *c
+	return nil
+}
+
+func (m *memStore) ChallengeSave(c *Challenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.challenges[c.ID] = *c
+	return nil
+}
+
+func (m *memStore) ChallengeByHash(hash string) (Challenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, x := range m.challenges {
+		if x.Hash == hash {
+			return x, nil
+		}
+	}
+	return Challenge{}, ErrNotFound
+}
+
+func (m *memStore) ChallengeDelete(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.challenges[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.challenges, id)
+	return nil
+}
+
+func (m *memStore) ChallengePurge(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for k, x := range m.challenges {
+		if x.Expires.Before(before) {
+			delete(m.challenges, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memStore) EnsureRecoveryCodes() error { return nil }
+
+func (m *memStore) RecoveryReplace(userID int64, codes []RecoveryCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, x := range m.recovery {
+		if x.UserID == userID {
+			delete(m.recovery, k)
+		}
+	}
+	for _, x := range codes {
+		x.ID = m.id()
+		m.recovery[x.ID] = x
+	}
+	return nil
+}
+
+func (m *memStore) RecoveryByUser(userID int64) ([]RecoveryCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []RecoveryCode
+	for _, x := range m.recovery {
+		if x.UserID == userID {
+			list = append(list, x)
+		}
+	}
+	return list, nil
+}
+
+func (m *memStore) RecoveryDelete(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.recovery[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.recovery, id)
+	return nil
+}
+
+func (m *memStore) EnsureThrottles() error { return nil }
+
+func (m *memStore) ThrottleByKey(key string) (Throttle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.throttles[key]; ok {
+		return t, nil
+	}
+	return Throttle{}, ErrNotFound
+}
+
+func (m *memStore) ThrottleFail(key string, since time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.throttles[key]
+	if !ok || t.Updated.Before(since) {
+		t = Throttle{ID: t.ID, Key: key}
+	}
+	if t.ID == 0 {
+		t.ID = m.id()
+	}
+	t.Failures++
+	t.Updated = time.Now()
+	m.throttles[key] = t
+	return nil
+}
+
+func (m *memStore) ThrottleClear(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.throttles, key)
+	return nil
+}
+
+func (m *memStore) ThrottlePurge(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for k, x := range m.throttles {
+		if x.Updated.Before(before) {
+			delete(m.throttles, k)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,159 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultLockoutUserThreshold   = 5
+	defaultLockoutClientThreshold = 20
+	defaultLockoutBase            = 30 * time.Second
+	defaultLockoutMax             = time.Hour
+	// throttleDecay is how long after the last failure a counter starts over.
+	throttleDecay = 24 * time.Hour
+)
+
+// Throttle counts failed logins against a user ("user:<id>"), the name
+// of an unknown user ("name:<sha-256>") or a client ("cli:<client-string>").
+//
+// Once Failures reaches its threshold, further logins are refused
+// until Updated + `LockoutBase` * 2^(Failures - threshold) has passed
+// (limited by `LockoutMax`).
+type Throttle struct {
+	ID       int64     `gorm:"auto_increment;unique_index;primary_key;column:id"`
+	Key      string    `gorm:"size:255;not null;uniqueIndex;column:throttle_key"`
+	Failures int       `gorm:"not null;column:failures"`
+	Updated  time.Time `gorm:"not null;column:updated"`
+}
+
+// TableName Set Throttle's table name to be `throttles`
+func (Throttle) TableName() string {
+	return "throttles"
+}
+
+func throttleUserKey(u *User) string      { return fmt.Sprintf("user:%d", u.ID) }
+func throttleClientKey(cli string) string { return "cli:" + cli }
+
+// throttleNameKey keys an unknown user by its normalized name so
+// it locks out just as an existing user would.
+func throttleNameKey(name string) string {
+	sum := sha256.Sum256([]byte(normalUserName(name)))
+	return "name:" + hex.EncodeToString(sum[:])
+}
+
+// lockoutInt returns a Service threshold or its default when zero;
+// a negative threshold disables throttling.
+func lockoutInt(value, def int) int {
+	if value == 0 {
+		return def
+	}
+	return value
+}
+
+// lockoutDuration returns a Service duration or its default when <= 0.
+func lockoutDuration(value, def time.Duration) time.Duration {
+	if value <= 0 {
+		return def
+	}
+	return value
+}
+
+// lockedUntil returns when t (with the given threshold) stops refusing logins.
+func (s *Service) lockedUntil(t Throttle, threshold int) time.Time {
+	if threshold < 0 || t.Failures < threshold || time.Since(t.Updated) > throttleDecay {
+		return time.Time{}
+	}
+	max := lockoutDuration(s.LockoutMax, defaultLockoutMax)
+	wait := lockoutDuration(s.LockoutBase, defaultLockoutBase)
+	for i := threshold; i < t.Failures && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return t.Updated.Add(wait)
+}
+
+// throttleKeys returns the keys and thresholds that apply to a login
+// attempt from client for u (which may be nil).  An unknown user
+// (no ID) is throttled by its Name so that locking out does not tell
+// whether a user exists.
+func (s *Service) throttleKeys(client string, u *User) ([]string, []int) {
+	keys := []string{throttleClientKey(client)}
+	thresholds := []int{lockoutInt(s.LockoutClientThreshold, defaultLockoutClientThreshold)}
+	userThreshold := lockoutInt(s.LockoutUserThreshold, defaultLockoutUserThreshold)
+	if u != nil && u.ID != 0 {
+		keys = append(keys, throttleUserKey(u))
+		thresholds = append(thresholds, userThreshold)
+	} else if u != nil && u.Name != "" {
+		keys = append(keys, throttleNameKey(u.Name))
+		thresholds = append(thresholds, userThreshold)
+	}
+	return keys, thresholds
+}
+
+// LoginLocked reports whether logins from client (see `getClientString`)
+// or for u (which may be nil) are refused and until when.
+func (s *Service) LoginLocked(client string, u *User) (time.Time, bool) {
+	var until time.Time
+	keys, thresholds := s.throttleKeys(client, u)
+	for i, key := range keys {
+		if thresholds[i] < 0 {
+			continue
+		}
+		t, err := storage().ThrottleByKey(key)
+		if err != nil {
+			continue
+		}
+		if x := s.lockedUntil(t, thresholds[i]); x.After(until) {
+			until = x
+		}
+	}
+	return until, time.Now().Before(until)
+}
+
+// LoginFailed counts a failed login from client for u (which may be nil).
+func (s *Service) LoginFailed(client string, u *User) {
+	keys, _ := s.throttleKeys(client, u)
+	for _, key := range keys {
+		if err := storage().ThrottleFail(key, time.Now().Add(-throttleDecay)); err != nil {
+			fmt.Printf("error(throttle-fail): %v\n", err)
+		}
+	}
+}
+
+// lockedOut reports a refused (locked out) attempt to j.
+func lockedOut(j *LogonModel, until time.Time) {
+	j.Detail = "Too many failed logins; try again later."
+	j.Status = false
+	j.Data = map[string]interface{}{"locked": true, "until": until}
+}
+
+// passwordLocked reports to j and returns true if the requesting client
+// or u is locked out; handlers confirming u's password check this first
+// and count a mismatch with `LoginFailed` just as "/login/" does.
+func (s *Service) passwordLocked(g *gin.Context, u *User, j *LogonModel) bool {
+	until, locked := s.LoginLocked(getClientString(g), u)
+	if locked {
+		lockedOut(j, until)
+	}
+	return locked
+}
+
+// LoginSucceeded clears the failed login count of u.
+// With TOTP enabled, a login succeeds once the code is supplied.
+func (s *Service) LoginSucceeded(u *User) {
+	storage().ThrottleClear(throttleUserKey(u))
+}
+
+// EnsureTableThrottles creates table [throttles] if not exist.
+func EnsureTableThrottles() {
+	if err := storage().EnsureThrottles(); err != nil {
+		fmt.Printf("error(ensure-table-throttles): %v\n", err)
+	}
+}
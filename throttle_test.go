@@ -0,0 +1,131 @@
+package session
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockedUntil(t *testing.T) {
+	s := &Service{LockoutBase: time.Second, LockoutMax: 10 * time.Second}
+	now := time.Now()
+	tests := []struct {
+		failures  int
+		threshold int
+		want      time.Duration
+	}{
+		{2, 3, 0},
+		{3, 3, time.Second},
+		{4, 3, 2 * time.Second},
+		{6, 3, 8 * time.Second},
+		{7, 3, 10 * time.Second}, // limited by LockoutMax
+		{9, -1, 0},               // disabled
+	}
+	for _, tt := range tests {
+		got := s.lockedUntil(Throttle{Failures: tt.failures, Updated: now}, tt.threshold)
+		if tt.want == 0 && !got.IsZero() || tt.want != 0 && !got.Equal(now.Add(tt.want)) {
+			t.Errorf("lockedUntil(%d failures, threshold %d) = %v, want now+%v", tt.failures, tt.threshold, got, tt.want)
+		}
+	}
+	old := Throttle{Failures: 9, Updated: now.Add(-throttleDecay - time.Minute)}
+	if got := s.lockedUntil(old, 3); !got.IsZero() {
+		t.Errorf("decayed throttle locked until %v", got)
+	}
+}
+
+func TestServeLoginLockout(t *testing.T) {
+	c := newTestClient(t, func(s *Service) {
+		s.LockoutUserThreshold = 2
+		s.LockoutClientThreshold = 5
+	})
+	defer c.close()
+	user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+	c.do(http.MethodGet, "/register/", user)
+	c.do(http.MethodGet, "/logout/", nil)
+	login := func(pass string) LogonModel {
+		_, j := c.do(http.MethodGet, "/login/", url.Values{"user": {"admin1"}, "pass": {pass}})
+		return j
+	}
+	if j := login("wrong"); j.Status || strings.Contains(j.Detail, "Too many") {
+		t.Fatalf("first failure: %+v", j)
+	}
+	if j := login("password1"); !j.Status {
+		t.Fatalf("login clears failures: %+v", j)
+	}
+	c.do(http.MethodGet, "/logout/", nil)
+	login("wrong")
+	login("wrong")
+	// the right password is not checked while locked out.
+	if j := login("password1"); j.Status || !strings.Contains(j.Detail, "Too many") {
+		t.Errorf("user not locked out: %+v", j)
+	}
+
+	// the client is locked out across user names.
+	for i := 0; i < 5; i++ {
+		c.do(http.MethodGet, "/login/", url.Values{"user": {"nobody1"}, "pass": {"wrong"}})
+	}
+	if _, j := c.do(http.MethodGet, "/login/", url.Values{"user": {"admin2"}, "pass": {"wrong"}}); !strings.Contains(j.Detail, "Too many") {
+		t.Errorf("client not locked out: %+v", j)
+	}
+}
+
+func TestServeLoginMFALockout(t *testing.T) {
+	c := newTestClient(t, func(s *Service) { s.LockoutUserThreshold = 2 })
+	defer c.close()
+	user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+	c.do(http.MethodGet, "/register/", user)
+	_, j := c.do(http.MethodGet, "/mfa/enroll/", nil)
+	secret, _ := j.Data.(map[string]interface{})["secret"].(string)
+	c.do(http.MethodGet, "/mfa/confirm/", url.Values{"code": {totpNow(t, secret, 0)}})
+	c.do(http.MethodGet, "/logout/", nil)
+
+	// wrong codes count as failed logins; the password does not clear them.
+	for _, path := range []string{"/login/mfa/", "/login/recovery/"} {
+		c.do(http.MethodGet, "/login/", user)
+		c.do(http.MethodGet, path, url.Values{"code": {"000000"}})
+	}
+	c.do(http.MethodGet, "/login/", user)
+	if _, j := c.do(http.MethodGet, "/login/mfa/", url.Values{"code": {totpNow(t, secret, 1)}}); j.Status || !strings.Contains(j.Detail, "Too many") {
+		t.Errorf("not locked out: %+v", j)
+	}
+}
+
+func TestServePasswordLockout(t *testing.T) {
+	for _, path := range []string{"/password/", "/unregister/"} {
+		c := newTestClient(t, func(s *Service) { s.LockoutUserThreshold = 2 })
+		c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}})
+		for i := 0; i < 2; i++ {
+			if _, j := c.do(http.MethodGet, path, url.Values{"pass": {"wrong"}, "newpass": {"password3"}}); j.Status {
+				t.Fatalf("%s: wrong password: %+v", path, j)
+			}
+		}
+		if _, j := c.do(http.MethodGet, path, url.Values{"pass": {"password1"}, "newpass": {"password3"}}); j.Status || !strings.Contains(j.Detail, "Too many") {
+			t.Errorf("%s: not locked out: %+v", path, j)
+		}
+		c.close()
+	}
+}
+
+func TestServeLoginLockoutUnknown(t *testing.T) {
+	c := newTestClient(t, func(s *Service) {
+		s.GenericLoginFailure = true
+		s.LockoutUserThreshold = 2
+	})
+	defer c.close()
+	c.do(http.MethodGet, "/register/", url.Values{"user": {"admin1"}, "pass": {"password1"}})
+	c.do(http.MethodGet, "/logout/", nil)
+	// an unknown user locks out just as an existing one.
+	for _, name := range []string{"admin1", "nobody1"} {
+		bad := url.Values{"user": {name}, "pass": {"wrong"}}
+		for i := 0; i < 2; i++ {
+			if _, j := c.do(http.MethodGet, "/login/", bad); j.Detail != defaultLoginFailure {
+				t.Fatalf("%s: %+v", name, j)
+			}
+		}
+		if _, j := c.do(http.MethodGet, "/login/", bad); !strings.Contains(j.Detail, "Too many") {
+			t.Errorf("%s: not locked out: %+v", name, j)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 (TOTP) parameters; these are what authenticator apps expect
+// when the otpauth:// URI omits them.
+const (
+	totpPeriod     = 30 // seconds per time-step
+	totpDigits     = 6
+	totpSkew       = 1 // time-steps accepted either side of now
+	totpSecretSize = 20
+)
+
+var (
+	// ErrTOTPCode is returned for a TOTP code that does not validate
+	// (or was already used).
+	ErrTOTPCode = errors.New("session: invalid totp code")
+	// ErrTOTPState is returned when enrolling a user that already has
+	// TOTP enabled or confirming/disabling a user that has not enrolled.
+	ErrTOTPState = errors.New("session: totp not in expected state")
+
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// newTOTPSecret returns a random base32 TOTP secret.
+func newTOTPSecret() string {
+	return totpEncoding.EncodeToString(NewSaltCSRNG(totpSecretSize))
+}
+
+// totpCode computes the RFC 4226 HOTP value of key for counter.
+func totpCode(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, bin%1000000)
+}
+
+// totpMatch validates code against secret at time t and returns the
+// matching time-step.  Only time-steps greater than after are accepted
+// so that a code can not be replayed.
+func totpMatch(secret, code string, t time.Time, after int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.Replace(secret, " ", "", -1)))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	now := t.Unix() / totpPeriod
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		step := now + i
+		if step <= after {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, uint64(step))), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI returns the otpauth:// URI for secret which may be rendered
+// as a QR code for authenticator apps.
+func totpURI(issuer, name, secret string) string {
+	label := url.PathEscape(issuer + ":" + name)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// TOTPEnroll generates a new TOTP secret for the user and returns it
+// along with its otpauth:// URI (for a QR code).
+//
+// TOTP is not enabled until the user proves possession of the secret
+// through `TOTPConfirm`.
+func (u *User) TOTPEnroll(issuer string) (secret, uri string, err error) {
+	if u.ID == 0 {
+		return "", "", ErrNotFound
+	}
+	if u.TOTPEnabled {
+		return "", "", ErrTOTPState
+	}
+	u.TOTPSecret = newTOTPSecret()
+	u.TOTPLast = 0
+	if err := storage().UserSave(u); err != nil {
+		return "", "", err
+	}
+	return u.TOTPSecret, totpURI(issuer, u.Name, u.TOTPSecret), nil
+}
+
+// TOTPConfirm enables TOTP for a user who has enrolled, given a valid code.
+func (u *User) TOTPConfirm(code string) error {
+	if u.TOTPEnabled || u.TOTPSecret == "" {
+		return ErrTOTPState
+	}
+	step, ok := totpMatch(u.TOTPSecret, code, time.Now(), u.TOTPLast)
+	if !ok {
+		return ErrTOTPCode
+	}
+	u.TOTPEnabled = true
+	u.TOTPLast = step
+	return storage().UserSave(u)
+}
+
+// TOTPDisable turns off TOTP for the user and forgets its secret
+// and recovery codes.  The user's password must be supplied for confirmation.
+func (u *User) TOTPDisable(pass string) error {
+	if !u.TOTPEnabled {
+		return ErrTOTPState
+	}
+	if !u.validate(pass) {
+		return ErrPasswordMismatch
+	}
+	u.TOTPEnabled = false
+	u.TOTPSecret = ""
+	u.TOTPLast = 0
+	if err := storage().RecoveryReplace(u.ID, nil); err != nil {
+		return err
+	}
+	return storage().UserSave(u)
+}
+
+// TOTPValidate checks code against the user's TOTP secret.
+// A code is accepted only once.
+func (u *User) TOTPValidate(code string) bool {
+	if !u.TOTPEnabled {
+		return false
+	}
+	step, ok := totpMatch(u.TOTPSecret, code, time.Now(), u.TOTPLast)
+	if !ok {
+		return false
+	}
+	u.TOTPLast = step
+	return storage().UserSave(u) == nil
+}
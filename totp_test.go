@@ -0,0 +1,119 @@
+package session
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfc6238Key is the SHA-1 seed of the RFC 6238 (appendix B) test vectors.
+var rfc6238Key = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B (SHA-1), truncated to our six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(rfc6238Key, uint64(tt.unix/totpPeriod)); got != tt.want {
+			t.Errorf("totpCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPMatch(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Key)
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+	tests := []struct {
+		name  string
+		code  string
+		after int64
+		want  bool
+	}{
+		{"current", totpCode(rfc6238Key, uint64(step)), 0, true},
+		{"previous step", totpCode(rfc6238Key, uint64(step-1)), 0, true},
+		{"next step", totpCode(rfc6238Key, uint64(step+1)), 0, true},
+		{"outside skew", totpCode(rfc6238Key, uint64(step-2)), 0, false},
+		{"replayed", totpCode(rfc6238Key, uint64(step)), step, false},
+		{"short", "12345", 0, false},
+		{"wrong", "000000", 0, false},
+	}
+	for _, tt := range tests {
+		if _, ok := totpMatch(secret, tt.code, now, tt.after); ok != tt.want {
+			t.Errorf("%s: totpMatch(%s) = %v, want %v", tt.name, tt.code, ok, tt.want)
+		}
+	}
+	if _, ok := totpMatch("not base32!", "050471", now, 0); ok {
+		t.Error("totpMatch accepted a malformed secret")
+	}
+}
+
+// totpNow returns the code of secret for the current time-step plus skew.
+func totpNow(t *testing.T, secret string, skew int64) string {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, uint64(time.Now().Unix()/totpPeriod+skew))
+}
+
+func TestServeMFA(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.close()
+	user := url.Values{"user": {"admin1"}, "pass": {"password1"}}
+	c.do(http.MethodGet, "/register/", user)
+
+	_, j := c.do(http.MethodGet, "/mfa/enroll/", nil)
+	secret, _ := j.Data.(map[string]interface{})["secret"].(string)
+	if !j.Status || secret == "" {
+		t.Fatalf("enroll: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/mfa/confirm/", url.Values{"code": {"000000"}}); j.Status {
+		t.Errorf("confirm with a wrong code: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/mfa/confirm/", url.Values{"code": {totpNow(t, secret, 0)}}); !j.Status {
+		t.Fatalf("confirm: %+v", j)
+	}
+	c.do(http.MethodGet, "/logout/", nil)
+
+	_, j = c.do(http.MethodGet, "/login/", user)
+	if mfa, _ := j.Data.(map[string]interface{})["mfa"].(bool); j.Status || !mfa {
+		t.Fatalf("login did not ask for a code: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/stat/", nil); j.Status {
+		t.Fatalf("logged in before the code: %+v", j)
+	}
+	// the code used to confirm can not be replayed.
+	if _, j := c.do(http.MethodGet, "/login/mfa/", url.Values{"code": {totpNow(t, secret, 0)}}); j.Status {
+		t.Errorf("replayed code accepted: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/login/mfa/", url.Values{"code": {totpNow(t, secret, 1)}}); !j.Status {
+		t.Fatalf("login/mfa: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/stat/", nil); !j.Status {
+		t.Errorf("stat after login/mfa: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/login/mfa/", url.Values{"code": {totpNow(t, secret, 1)}}); j.Status {
+		t.Errorf("challenge used twice: %+v", j)
+	}
+
+	if _, j := c.do(http.MethodGet, "/mfa/disable/", url.Values{"pass": {"password2"}}); j.Status {
+		t.Errorf("disable with a wrong password: %+v", j)
+	}
+	if _, j := c.do(http.MethodGet, "/mfa/disable/", url.Values{"pass": {"password1"}}); !j.Status {
+		t.Errorf("disable: %+v", j)
+	}
+	if _, j := c.browser().do(http.MethodGet, "/login/", user); !j.Status {
+		t.Errorf("login after disable: %+v", j)
+	}
+}
@@ -6,17 +6,28 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 // User structure
 type User struct {
-	ID   int64  `gorm:"auto_increment;unique_index;primary_key;column:id"`
-	Name string `gorm:"size:27;column:user"`
-	Salt string `gorm:"size:432;column:salt"`
-	Hash string `gorm:"size:432;column:hash"`
+	ID          int64  `gorm:"auto_increment;unique_index;primary_key;column:id"`
+	Name        string `gorm:"size:27;column:user"`
+	Salt        string `gorm:"size:432;column:salt"`       // legacy hashes only
+	Hash        string `gorm:"size:432;column:hash"`       // see PasswordHasher
+	PepperID    string `gorm:"size:32;column:pepper_id"`   // see Service.Peppers
+	TOTPSecret  string `gorm:"size:64;column:totp_secret"` // base32
+	TOTPEnabled bool   `gorm:"column:totp_enabled"`
+	TOTPLast    int64  `gorm:"column:totp_last"` // last accepted time-step
 }
 
+var (
+	// ErrPasswordMismatch is returned when a supplied password does not
+	// validate against the stored hash.
+	ErrPasswordMismatch = errors.New("session: password did not match")
+	// ErrUserExists is returned when registering a name that is taken.
+	ErrUserExists = errors.New("session: user exists")
+)
+
 // TableName Set User's table name to be `users`
 func (User) TableName() string {
 	return "users"
@@ -24,12 +35,8 @@ func (User) TableName() string {
 
 // UserGetList gets a map of all `User`s by ID.
 func UserGetList() map[int64]User {
-	var users []User
 	usermap := make(map[int64]User)
-	db, err := iniC("error(user-get-list) loading database\n")
-	//defer db.Close()
-	if !err {
-		db.Find(&users)
+	if users, err := storage().UserList(); err == nil {
 		// fmt.Printf("- found %d entries\n", len(users))
 		for _, x := range users {
 			usermap[x.ID] = x
@@ -41,35 +48,36 @@ func UserGetList() map[int64]User {
 /* http://jinzhu.me/gorm/crud.html#query */
 
 // ByName gets a user by [name].
-// If `u` properties are set, then those are defaulted in FirstOrInit
+// name is normalized per `Service.UsernamePolicy`; users stored before
+// the policy normalized names are still found by their name as given.
 //
 // return true on success
 func (u *User) ByName(name string) bool {
 	// fmt.Printf("ByName(%s)\n", name)
-	db, err := iniC("error(user-by-name) loading database\n")
-	result := false
-	//defer db.Close()
-	if !err {
-		if err1 := db.Where("[user] = ?", name).First(&u).Error; err1 != nil {
-			if errors.Is(err1, gorm.ErrRecordNotFound) && false { // THIS PRINT ERROR IS IGNORED
-				println("ERROR: record not found")
-			}
-		}
-		if u.Name == name {
-			result = true
-		}
+	n := normalUserName(name)
+	x, err := storage().UserByName(n)
+	if err != nil && n != name {
+		// stored as given, before `UsernamePolicy` normalized names.
+		n = name
+		x, err = storage().UserByName(n)
 	}
-	// fmt.Printf("!-> FOUND %s, %d, result-found-success: %v\n", u.Name, u.ID, result)
-	return result
+	if err != nil {
+		return false
+	}
+	*u = x
+	// fmt.Printf("!-> FOUND %s, %d\n", u.Name, u.ID)
+	return u.Name == n
 }
 
 // ByID gets a user by [id].
+//
+// return true on success
 func (u *User) ByID(id int64) bool {
-	db, err := iniC("error(user-by-id) loading database\n")
-	//defer db.Close()
-	if !err {
-		db.FirstOrInit(u, User{ID: id})
+	x, err := storage().UserByID(id)
+	if err != nil {
+		return false
 	}
+	*u = x
 	return u.ID == id
 }
 
@@ -80,6 +88,10 @@ func (u *User) ByID(id int64) bool {
 // the `ClientIP()` and store that value to our database in order to
 // validate a given user-session.
 //
+// A user may hold any number of sessions; one per device (browser).
+// With a `*gin.Context` the device is identified by the `<host>_dev`
+// cookie which is created here if not present.
+//
 // returns true on error
 func (u *User) CreateSession(r interface{}, host string, keepAlive bool) (bool, Session) {
 
@@ -89,67 +101,86 @@ func (u *User) CreateSession(r interface{}, host string, keepAlive bool) (bool,
 	if service == nil {
 		service = DefaultService()
 	}
-	// println("-- setup session data (create-session)")
-	// println("==================")
 	// fmt.Printf("Host: %s, UserID: %v, keepAlive: %v, salt-size: %v, created: %s\n", host, u.ID, keepAlive, defaultSaltSize, t.Local().String())
-	// println("---------")
-	// fmt.Printf("Service: %v, r (interface): %v\n", service, r)
 	sess := Session{
 		Host:      host,
 		UserID:    u.ID,
 		KeepAlive: keepAlive,
-		SessID:    toUBase64(NewSaltString(defaultSaltSize)),
 		Created:   t,
 		Expires:   service.AddDate(t),
 	}
 
+	sess.newSessID()
+
 	// acceptable client is of type: gin.Context, nil and string
-	// looks like we're giving it nil.
-	// anyways, attempt to load the nil client's name.
-	// host?
 	sess.Client = getClientString(r)
+	sess.Agent = getAgentString(r)
+	if sess.Device = getDeviceString(r, host); sess.Device == "" {
+		sess.Device = newDeviceString(r, host)
+	}
 	// fmt.Printf("My Client String: %s\n", sess.Client)
-	// println("---------")
-
-	// guess we're making sure the database exists again?
-
-	//defer db.Close()
-	var sx = Session{}
-	if berry, xs := sx.HasSessionForUser(u); !berry {
-		if errors.Is(xs, gorm.ErrRecordNotFound) { // lets just pretend this didn't happen
-			println("--- ERROR: record not found")
-			db, _ := iniC("error(user-create-session) loading database\n")
-			println("--- CREATING SESSION")
-
-			mrr := db.Create(&sess)
-			if mrr.Error != nil {
-				println("ERROR: error creating session data.", mrr.Error.Error())
-			}
-
-			if err := mrr.Error; err != nil {
-				fmt.Printf("ERROR: %s\n", err.Error())
-				println("Session creation ERROR", u.Name, u.ID)
-			} else if mrr.RowsAffected == 1 {
-				println("- SESSION CREATED.", u.Name, u.ID)
-				result = false
-			} else {
-				fmt.Printf("so what now? %v %v\n", db.RowsAffected, sess.ID)
-			}
-
-		} else {
-			println("--- ERROR: unknown error.")
-		}
+
+	if err := storage().SessionCreate(&sess); err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		println("Session creation ERROR", u.Name, u.ID)
 	} else {
-		if sx.IsValid() {
-			fmt.Printf("- Session EXPIRES %s!\n", sx.Expires)
-		} else {
-			fmt.Printf("- Session EXPIRED of %s!\n", sx.Expires)
-		}
+		result = false
 	}
 
 	return result, sess
 }
 
+// Sessions lists every session owned by the user (on any host),
+// including expired sessions that have not yet been purged.
+func (u *User) Sessions() ([]Session, error) {
+	return storage().SessionsByUser(u.ID)
+}
+
+// RevokeSession expires the user's session matching id.
+//
+// Returns ErrNotFound if the session does not exist or is not
+// owned by the user.
+func (u *User) RevokeSession(id int64) error {
+	sess, err := storage().SessionByID(id)
+	if err != nil {
+		return err
+	}
+	if sess.UserID != u.ID {
+		return ErrNotFound
+	}
+	sess.Destroy(false)
+	sess.KeepAlive = false
+	return storage().SessionSave(&sess)
+}
+
+// RevokeAllSessions expires every session owned by the user with
+// exception to except, which may be nil to revoke all of them.
+//
+// Returns the number of sessions revoked.
+func (u *User) RevokeAllSessions(except *Session) (int64, error) {
+	var exceptID int64
+	if except != nil {
+		exceptID = except.ID
+	}
+	return storage().SessionRevokeByUser(u.ID, exceptID)
+}
+
+// RenameSession sets the label of the user's session matching id.
+//
+// Returns ErrNotFound if the session does not exist or is not
+// owned by the user.
+func (u *User) RenameSession(id int64, name string) error {
+	sess, err := storage().SessionByID(id)
+	if err != nil {
+		return err
+	}
+	if sess.UserID != u.ID {
+		return ErrNotFound
+	}
+	sess.Name = truncate(name, 64)
+	return storage().SessionSave(&sess)
+}
+
 type UserErrorConst int
 
 const (
@@ -188,50 +219,116 @@ func (u *User) Create_CheckErr(name string, pass string) UserErrorConst {
 // Create attempts to create a user and returns success or failure.
 // If a user allready exists results in failure.
 //
-// Returns
+// Returns a `UserErrorConst` (as int); see `Register` for details
+// of a policy failure.
 func (u *User) Create(name string, pass string) int {
-
-	if len(name) < 5 {
-		return int(LenName)
-	}
-	if len(pass) < 5 {
+	var perr *PolicyError
+	err := u.Register(name, pass)
+	switch {
+	case err == nil:
+		return int(Perfection)
+	case errors.Is(err, ErrUserExists):
+		return int(HasName)
+	case errors.As(err, &perr):
+		if perr.Violations[0].Field == "user" {
+			return int(LenName)
+		}
 		return int(LenPass)
 	}
+	return int(CheckDB)
+}
 
-	if u.ByName(name) {
-		return int(HasName)
+// Register attempts to create a user.
+//
+// name is normalized per `Service.UsernamePolicy` and both name and pass
+// are checked against our policies; failures are returned as a *PolicyError.
+// Returns ErrUserExists if a user allready exists.
+func (u *User) Register(name string, pass string) error {
+
+	name = normalUserName(name)
+	if err := checkRegistration(name, pass); err != nil {
+		return err
 	}
 
-	// make if no table
-	db, err := iniC("error(user-create): loading database\n")
-	if err {
-		return int(CheckDB)
+	if u.ByName(name) {
+		return ErrUserExists
 	}
 
-	// salt salt hash hash
-	bsalt := NewSaltCSRNG(defaultSaltSize)
+	hash, pepperID, err := hashPassword(pass)
+	if err != nil {
+		return err
+	}
 	u.Name = name
-	u.Salt = bytesToBase64(bsalt)
-	u.Hash = bytesToBase64(GetPasswordHash(pass, bsalt))
-	// fmt.Printf("--> %s, %s, %v\n", u.Name, pass, u.Salt)
+	u.Salt = ""
+	u.Hash = hash
+	u.PepperID = pepperID
+	// fmt.Printf("--> %s, %s, %v\n", u.Name, pass, u.Hash)
 
-	// defer db.Close()
-	if err := db.Create(u).Error; err != nil {
+	if err := storage().UserCreate(u); err != nil {
 		fmt.Printf("ERROR: %s\n", err.Error())
+		return err
 	}
 
-	return 0
+	return nil
+}
+
+// ImportUser creates a user with a password hash from another system
+// such as "$2b$..." (bcrypt) or "pbkdf2_sha256$..." (django); see
+// `Service.PasswordHashers`.  The hash is replaced with one of
+// `Service.PasswordHasher` once the user logs in.
+//
+// hash is expected to be unpeppered.  name is normalized but, unlike
+// `Register`, not checked against our policies.
+//
+// Returns ErrHashFormat if no hasher recognizes hash, ErrHashCost if its
+// cost parameters exceed our limits or ErrUserExists if a user allready
+// exists.
+func ImportUser(name, hash string) (User, error) {
+	u := User{}
+	h, _, found := detectHasher(hash)
+	if !found {
+		return u, ErrHashFormat
+	}
+	if err := checkHashCost(h, hash); err != nil {
+		return u, err
+	}
+	name = normalUserName(name)
+	if u.ByName(name) {
+		return User{}, ErrUserExists
+	}
+	u.Name = name
+	u.Hash = hash
+	if err := storage().UserCreate(&u); err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return User{}, err
+	}
+	return u, nil
 }
 
 // validate checks against a provided salt and hash.
 // This method does not actually look anything up from a database.
 //
 // Salt and Hash MUST BE PRESENT before calling!
+//
+// On success, a (stored) hash created with outdated parameters, by
+// another `PasswordHasher`, with other than the current pepper or in the
+// legacy salt/hash form is replaced with a current one.
 func (u *User) validate(pass string) bool {
-	result := CheckPassword(
-		pass,
-		fromBase64(u.Salt),
-		fromBase64(u.Hash))
+	var result, stale bool
+	if _, _, found := detectHasher(u.Hash); found {
+		result, stale = verifyPassword(pass, u.Hash, u.PepperID)
+	} else {
+		result = CheckPassword(
+			pass,
+			fromBase64(u.Salt),
+			fromBase64(u.Hash))
+		stale = true
+	}
+	if result && stale && u.ID != 0 {
+		if err := u.setPassword(pass); err != nil {
+			fmt.Printf("ERROR(rehash): %s\n", err.Error())
+		}
+	}
 	return result
 }
 
@@ -239,40 +336,34 @@ func (u *User) validate(pass string) bool {
 // we use the user's [name] to find the table-record
 // and then validate the password.
 //
+// A hash is computed whether or not the user exists so that unknown
+// users can not be told apart by timing.
+//
 // return false on error
 func (u *User) ValidatePassword(pass string) bool {
 	// fmt.Println("==> ValidatePassword")
-	// open the database
-	db, err := iniC("error(validate-password) loading database\n")
-	if err {
-		return false
-	}
-
 	result := false
-	tempUser := User{Name: "really?"}
-	//db.LogMode(dataLogging)
-	db.FirstOrInit(&tempUser, User{Name: u.Name})
-
-	if db.RowsAffected == 0 && tempUser.Name != u.Name {
-		//db.Close()
+	tempUser, err := storage().UserByName(u.Name)
+	if err != nil {
 		// fmt.Println("Record not found")
+		dummyVerify(pass)
 		return false
 	}
 	fmt.Printf("User-Name: %s, id: %v\n", u.Name, u.ID)
 
-	//defer db.Close()
 	if tempUser.Name != u.Name {
 		// fmt.Printf("- no user found. %v\n", tempUser)
-		// may as well just return false here, right?
+		dummyVerify(pass)
 	} else {
 		result = tempUser.validate(pass)
+		u.Salt, u.Hash, u.PepperID = tempUser.Salt, tempUser.Hash, tempUser.PepperID
 	}
 
 	return result
 }
 
 // UserSession grabs a session from sessions table matching `user_id`, `host`
-// and `cli-key` (is the app-id used to store SessID info).
+// and `device` (the device-id cookie of the client).
 //
 // Nothing is validated, we just grab the `sessions.session` so that it
 // can be reused and/or updated.
@@ -280,15 +371,14 @@ func (u *User) ValidatePassword(pass string) bool {
 // returns (`Session`, `success` bool)
 func (u *User) UserSession(host string, client *gin.Context) (Session, bool) {
 	// fmt.Println("==> UserSession()")
-	clistr := getClientString(client)
-	sess := Session{}
-	db, err := iniC("error(validate-session) loading database\n")
-	if err {
+	device := getDeviceString(client, host)
+	if device == "" {
+		return Session{}, false
+	}
+	sess, err := storage().SessionByDevice(device, host, u.ID)
+	if err != nil {
 		return sess, false
 	}
-	//db.LogMode(dataLogging)
-	//defer db.Close()
-	db.First(&sess, "[cli-key] = ? AND [host] = ? AND [user_id] = ?", clistr, host, u.ID)
 	// fmt.Printf("  --> MATCH: %v\n", sess.UserID == u.ID)
 	return sess, sess.UserID == u.ID
 }
@@ -322,14 +412,64 @@ func (u *User) ValidateSessionByUserID(host string, client *gin.Context) bool {
 	return false
 }
 
+// ChangePassword validates oldPass against the stored hash then
+// stores newPass with a freshly generated salt; see `NewPasswordHash`.
+//
+// Existing sessions are left as they are; see `RevokeAllSessions`.
+//
+// Returns a *PolicyError if newPass fails `Service.PasswordPolicy` or
+// ErrPasswordMismatch if oldPass does not validate.
+func (u *User) ChangePassword(oldPass, newPass string) error {
+	if u.ID == 0 {
+		return ErrNotFound
+	}
+	if err := checkPassword(u.Name, newPass); err != nil {
+		return err
+	}
+	if !u.validate(oldPass) {
+		return ErrPasswordMismatch
+	}
+	return u.setPassword(newPass)
+}
+
+// setPassword stores pass with a freshly generated salt.
+func (u *User) setPassword(pass string) error {
+	hash, pepperID, err := hashPassword(pass)
+	if err != nil {
+		return err
+	}
+	u.Salt = ""
+	u.Hash = hash
+	u.PepperID = pepperID
+	if err := storage().UserSave(u); err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return err
+	}
+	return nil
+}
+
+// Delete removes the user along with all of the user's sessions.
+// The user's password must be supplied for confirmation.
+//
+// Returns ErrPasswordMismatch if pass does not validate.
+func (u *User) Delete(pass string) error {
+	if u.ID == 0 {
+		return ErrNotFound
+	}
+	if !u.ValidatePassword(pass) {
+		return ErrPasswordMismatch
+	}
+	if err := storage().UserDelete(u.ID); err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return err
+	}
+	*u = User{}
+	return nil
+}
+
 // EnsureTableUsers creates table [users] if not exist.
 func EnsureTableUsers() {
-	var u User
-	db, _ := iniK("error(ensure-table-users) loading db (perhaps expected)\n")
-	// if !e {
-	//defer db.Close()
-	if !db.Migrator().HasTable(u) {
-		db.Migrator().CreateTable(u)
-	}
-	// }
+	if err := storage().EnsureUsers(); err != nil {
+		fmt.Printf("error(ensure-table-users): %v\n", err)
+	}
 }
@@ -21,3 +21,11 @@ func WrapURIExpression(input string) []string {
 	}
 	return data
 }
+
+// truncate limits input to n bytes, dropping a trailing partial rune.
+func truncate(input string, n int) string {
+	if len(input) > n {
+		return strings.ToValidUTF8(input[:n], "")
+	}
+	return input
+}